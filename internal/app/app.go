@@ -2,15 +2,18 @@ package app
 
 import (
 	"context"
-	"crypto/md5"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/kloudlite/kloudmeter/pkg/errors"
 	"github.com/kloudlite/kloudmeter/pkg/functions"
 	httpServer "github.com/kloudlite/kloudmeter/pkg/http-server"
 	"github.com/kloudlite/kloudmeter/pkg/logging"
-	"github.com/nats-io/nats.go/jetstream"
+	"google.golang.org/grpc/codes"
 
 	"github.com/kloudlite/kloudmeter/internal/domain"
 	"github.com/kloudlite/kloudmeter/internal/domain/entities"
@@ -32,6 +35,34 @@ var Module = fx.Module("app",
 
 	kv.NewNatsKvRepoFx[*entities.Meter]("meters"),
 	kv.NewNatsKvRepoFx[*entities.Reading]("readings"),
+	kv.NewNatsKvRepoFx[*entities.DurationState]("duration-states"),
+	kv.NewNatsKvRepoFx[*entities.EventType]("event-types"),
+	kv.NewNatsKvRepoFx[*entities.PricePlan]("price-plans"),
+	kv.NewNatsKvRepoFx[*entities.Limit]("limits"),
+	kv.NewNatsKvRepoFx[*entities.AlertRule]("alert-rules"),
+	kv.NewNatsKvRepoFx[*entities.AlertDelivery]("alert-deliveries"),
+	kv.NewNatsKvRepoFx[*entities.ClosedPeriod]("closed-periods"),
+	kv.NewNatsKvRepoFx[*entities.Adjustment]("usage-adjustments"),
+
+	fx.Provide(func(jc *nats.JetstreamClient) (domain.MeterVersionsRepo, error) {
+		return kv.NewNatsKVRepo[*entities.Meter](context.TODO(), "meter-versions", jc)
+	}),
+
+	fx.Provide(func(jc *nats.JetstreamClient) (domain.EventTypeVersionsRepo, error) {
+		return kv.NewNatsKVRepo[*entities.EventType](context.TODO(), "event-type-versions", jc)
+	}),
+
+	fx.Provide(func(jc *nats.JetstreamClient, ev *env.Env) (kv.Repo[*entities.Alert], error) {
+		return kv.NewNatsKVRepoWithTTL[*entities.Alert](context.TODO(), "alert-states", ev.AlertStateTTL, jc)
+	}),
+
+	fx.Provide(func(jc *nats.JetstreamClient, ev *env.Env) (kv.Repo[*entities.CumulativePoint], error) {
+		return kv.NewNatsKVRepoWithTTL[*entities.CumulativePoint](context.TODO(), "otlp-series", ev.OtlpSeriesTTL, jc)
+	}),
+
+	fx.Provide(func(jc *nats.JetstreamClient) (domain.SnapshotsRepo, error) {
+		return kv.NewNatsKVRepo[*entities.Reading](context.TODO(), "usage-snapshots", jc)
+	}),
 
 	domain.Module,
 
@@ -42,13 +73,19 @@ var Module = fx.Module("app",
 	fx.Invoke(func(lf fx.Lifecycle, d domain.Domain, logr logging.Logger) {
 		lf.Append(fx.Hook{
 			OnStart: func(context.Context) error {
+				if err := d.StartPeriodsCache(context.TODO()); err != nil {
+					return err
+				}
 				go func() {
 					err := d.StartConsumingEvents(context.TODO())
 					if err != nil {
 						logr.Errorf(err, "could not process events")
 					}
 				}()
-				return nil
+				if err := d.StartEntitlementsCache(context.TODO()); err != nil {
+					return err
+				}
+				return d.StartAlerts(context.TODO())
 			},
 			OnStop: func(ctx context.Context) error {
 				return nil
@@ -57,8 +94,14 @@ var Module = fx.Module("app",
 	}),
 
 	fx.Invoke(
-		func(server httpServer.Server, d domain.Domain, mp domain.MeterProducer) error {
+		func(server httpServer.Server, d domain.Domain, mp domain.MeterProducer, ev *env.Env) error {
+			if err := server.SetupMetrics("/metrics", d.ReadingsCollector()); err != nil {
+				return err
+			}
+
 			app := server.Raw()
+			app.Use(instrumentRequests)
+
 			app.Post(
 				"/api/create-meter", func(ctx *fiber.Ctx) error {
 
@@ -73,16 +116,61 @@ var Module = fx.Module("app",
 						return ctx.Status(http.StatusBadRequest).JSON(map[string]string{"status": "error", "message": err.Error()})
 					}
 
-					if err = d.RegisterMeter(ctx.Context(), meter); err != nil {
+					m, err := d.RegisterMeter(ctx.Context(), meter)
+					if err != nil {
 						return ctx.Status(http.StatusBadRequest).JSON(map[string]string{"status": "error", "message": err.Error()})
 					}
 
-					d.AddMeterToConsume(&meter)
+					d.AddMeterToConsume(m)
 
 					return ctx.Status(http.StatusAccepted).JSON(map[string]string{"status": "ok"})
 				},
 			)
 
+			app.Put(
+				"/api/meter", func(ctx *fiber.Ctx) error {
+					var meter entities.Meter
+
+					if err := ctx.BodyParser(&meter); err != nil {
+						return ctx.Status(http.StatusBadRequest).JSON(map[string]string{"status": "error", "message": err.Error()})
+					}
+
+					m, err := d.UpdateMeter(ctx.Context(), meter)
+					if err != nil {
+						if errors.Is(err, domain.MeterNotFoundError) {
+							return ctx.Status(http.StatusNotFound).JSON(map[string]string{"status": "error", "message": "meter not found, note that id, eventType and aggregation of a meter can not be updated"})
+						}
+						return ctx.Status(http.StatusBadRequest).JSON(map[string]string{"status": "error", "message": err.Error()})
+					}
+
+					d.AddMeterToConsume(m)
+
+					return ctx.Status(http.StatusAccepted).JSON(m)
+				},
+			)
+
+			app.Get(
+				"/api/meter/backfill", func(ctx *fiber.Ctx) error {
+					progress, err := d.GetBackfillProgress(ctx.Context(), ctx.Query("key"))
+					if err != nil {
+						return ctx.Status(http.StatusBadRequest).JSON(map[string]string{"status": "error", "message": err.Error()})
+					}
+
+					return ctx.Status(http.StatusOK).JSON(progress)
+				},
+			)
+
+			app.Get(
+				"/api/meter/versions", func(ctx *fiber.Ctx) error {
+					a, err := d.ListMeterVersions(ctx.Context(), ctx.Query("key"))
+					if err != nil {
+						return ctx.Status(http.StatusBadRequest).JSON(map[string]string{"status": "error", "message": err.Error()})
+					}
+
+					return ctx.Status(http.StatusOK).JSON(a)
+				},
+			)
+
 			app.Get(
 				"/api/meters", func(ctx *fiber.Ctx) error {
 					a, err := d.ListMeters(ctx.Context())
@@ -125,6 +213,167 @@ var Module = fx.Module("app",
 				return ctx.Status(http.StatusOK).JSON(a)
 			})
 
+			app.Get("/api/readings/window", func(ctx *fiber.Ctx) error {
+				query := domain.WindowQuery{
+					MeterKey: ctx.Query("meter"),
+					Subject:  ctx.Query("subject"),
+					Segment:  ctx.Query("segment"),
+					Window:   entities.WindowSize(ctx.Query("window")),
+				}
+
+				var err error
+				if at := ctx.Query("at"); at != "" {
+					if query.From, err = time.Parse(time.RFC3339, at); err != nil {
+						return ctx.Status(http.StatusBadRequest).JSON(map[string]string{"status": "error", "message": "at must be in RFC3339 format"})
+					}
+					query.To = query.From
+				} else {
+					if query.From, err = time.Parse(time.RFC3339, ctx.Query("from")); err != nil {
+						return ctx.Status(http.StatusBadRequest).JSON(map[string]string{"status": "error", "message": "from must be in RFC3339 format"})
+					}
+					query.To = query.From
+					if to := ctx.Query("to"); to != "" {
+						if query.To, err = time.Parse(time.RFC3339, to); err != nil {
+							return ctx.Status(http.StatusBadRequest).JSON(map[string]string{"status": "error", "message": "to must be in RFC3339 format"})
+						}
+					}
+				}
+
+				a, err := d.ListWindowReadings(ctx.Context(), query)
+				if err != nil {
+					return ctx.Status(http.StatusBadRequest).JSON(map[string]string{"status": "error", "message": err.Error()})
+				}
+
+				return ctx.Status(http.StatusOK).JSON(a)
+			})
+
+			app.Get("/api/readings/query", func(ctx *fiber.Ctx) error {
+				query := domain.ReadingsQuery{
+					MeterKey: ctx.Query("meter"),
+					Subject:  ctx.Query("subject"),
+					Segment:  ctx.Query("segment"),
+					Window:   entities.WindowSize(ctx.Query("window")),
+					Sort:     ctx.Query("sort"),
+					Cursor:   ctx.Query("cursor"),
+				}
+
+				var err error
+				if from := ctx.Query("from"); from != "" {
+					if query.From, err = time.Parse(time.RFC3339, from); err != nil {
+						return ctx.Status(http.StatusBadRequest).JSON(map[string]string{"status": "error", "message": "from must be in RFC3339 format"})
+					}
+				}
+
+				if to := ctx.Query("to"); to != "" {
+					if query.To, err = time.Parse(time.RFC3339, to); err != nil {
+						return ctx.Status(http.StatusBadRequest).JSON(map[string]string{"status": "error", "message": "to must be in RFC3339 format"})
+					}
+				}
+
+				if limit := ctx.Query("limit"); limit != "" {
+					if query.Limit, err = strconv.Atoi(limit); err != nil {
+						return ctx.Status(http.StatusBadRequest).JSON(map[string]string{"status": "error", "message": "limit must be a number"})
+					}
+				}
+
+				// dimensions=region:us-east,tier:premium
+				if dimensions := ctx.Query("dimensions"); dimensions != "" {
+					query.Dimensions = map[string]string{}
+					for _, pair := range strings.Split(dimensions, ",") {
+						name, value, ok := strings.Cut(pair, ":")
+						if !ok {
+							return ctx.Status(http.StatusBadRequest).JSON(map[string]string{"status": "error", "message": "dimensions must be of format name:value[,name:value]"})
+						}
+						query.Dimensions[name] = value
+					}
+				}
+
+				page, err := d.QueryReadings(ctx.Context(), query)
+				if err != nil {
+					if errors.Is(err, domain.MeterNotFoundError) {
+						return ctx.Status(http.StatusNotFound).JSON(map[string]string{"status": "error", "message": err.Error()})
+					}
+					return ctx.Status(http.StatusBadRequest).JSON(map[string]string{"status": "error", "message": err.Error()})
+				}
+
+				return ctx.Status(http.StatusOK).JSON(page)
+			})
+
+			app.Get("/api/meters/:id/series", func(ctx *fiber.Ctx) error {
+				query := domain.SeriesQuery{
+					MeterKey: ctx.Params("id"),
+					Subject:  ctx.Query("subject"),
+					Step:     ctx.Query("step"),
+					Quantity: ctx.Query("quantity"),
+					To:       time.Now().UTC(),
+				}
+
+				var err error
+				if query.From, err = time.Parse(time.RFC3339, ctx.Query("from")); err != nil {
+					return ctx.Status(http.StatusBadRequest).JSON(map[string]string{"status": "error", "message": "from must be in RFC3339 format"})
+				}
+
+				if to := ctx.Query("to"); to != "" {
+					if query.To, err = time.Parse(time.RFC3339, to); err != nil {
+						return ctx.Status(http.StatusBadRequest).JSON(map[string]string{"status": "error", "message": "to must be in RFC3339 format"})
+					}
+				}
+
+				series, err := d.QuerySeries(ctx.Context(), query)
+				if err != nil {
+					if errors.Is(err, domain.MeterNotFoundError) {
+						return ctx.Status(http.StatusNotFound).JSON(map[string]string{"status": "error", "message": err.Error()})
+					}
+					return ctx.Status(http.StatusBadRequest).JSON(map[string]string{"status": "error", "message": err.Error()})
+				}
+
+				return ctx.Status(http.StatusOK).JSON(series)
+			})
+
+			app.Get("/api/readings/rollup", func(ctx *fiber.Ctx) error {
+				query := domain.RollupQuery{
+					MeterKey: ctx.Query("meter"),
+					Subject:  ctx.Query("subject"),
+					Window:   entities.WindowSize(ctx.Query("window")),
+				}
+
+				var err error
+				if from := ctx.Query("from"); from != "" {
+					if query.From, err = time.Parse(time.RFC3339, from); err != nil {
+						return ctx.Status(http.StatusBadRequest).JSON(map[string]string{"status": "error", "message": "from must be in RFC3339 format"})
+					}
+				}
+
+				if to := ctx.Query("to"); to != "" {
+					if query.To, err = time.Parse(time.RFC3339, to); err != nil {
+						return ctx.Status(http.StatusBadRequest).JSON(map[string]string{"status": "error", "message": "to must be in RFC3339 format"})
+					}
+				}
+
+				// dimensions=region:us-east,tier:premium
+				if dimensions := ctx.Query("dimensions"); dimensions != "" {
+					query.Dimensions = map[string]string{}
+					for _, pair := range strings.Split(dimensions, ",") {
+						name, value, ok := strings.Cut(pair, ":")
+						if !ok {
+							return ctx.Status(http.StatusBadRequest).JSON(map[string]string{"status": "error", "message": "dimensions must be of format name:value[,name:value]"})
+						}
+						query.Dimensions[name] = value
+					}
+				}
+
+				if groupBy := ctx.Query("groupBy"); groupBy != "" {
+					query.GroupBy = strings.Split(groupBy, ",")
+				}
+
+				a, err := d.RollupReadings(ctx.Context(), query)
+				if err != nil {
+					return ctx.Status(http.StatusBadRequest).JSON(map[string]string{"status": "error", "message": err.Error()})
+				}
+
+				return ctx.Status(http.StatusOK).JSON(a)
+			})
+
 			app.Delete(
 				"/api/meter", func(ctx *fiber.Ctx) error {
 					key := ctx.Query("key", "")
@@ -134,38 +383,492 @@ var Module = fx.Module("app",
 					}
 
 					if err := d.DeleteMeter(ctx.Context(), key); err != nil {
+						if errors.Is(err, domain.MeterNotFoundError) {
+							return ctx.Status(http.StatusNotFound).JSON(map[string]string{"status": "error", "message": err.Error()})
+						}
+						return ctx.Status(http.StatusBadRequest).JSON(map[string]string{"status": "error", "message": err.Error()})
+					}
+
+					return ctx.Status(http.StatusAccepted).JSON(map[string]string{"status": "ok"})
+				},
+			)
+
+			app.Post(
+				"/api/create-price-plan", func(ctx *fiber.Ctx) error {
+					var plan entities.PricePlan
+
+					if err := ctx.BodyParser(&plan); err != nil {
+						return ctx.Status(http.StatusBadRequest).JSON(map[string]string{"status": "error", "message": err.Error()})
+					}
+
+					p, err := d.CreatePricePlan(ctx.Context(), plan)
+					if err != nil {
+						return ctx.Status(http.StatusBadRequest).JSON(map[string]string{"status": "error", "message": err.Error()})
+					}
+
+					return ctx.Status(http.StatusAccepted).JSON(p)
+				},
+			)
+
+			app.Put(
+				"/api/price-plan", func(ctx *fiber.Ctx) error {
+					var plan entities.PricePlan
+
+					if err := ctx.BodyParser(&plan); err != nil {
+						return ctx.Status(http.StatusBadRequest).JSON(map[string]string{"status": "error", "message": err.Error()})
+					}
+
+					p, err := d.UpdatePricePlan(ctx.Context(), plan)
+					if err != nil {
+						if errors.Is(err, domain.PricePlanNotFoundError) {
+							return ctx.Status(http.StatusNotFound).JSON(map[string]string{"status": "error", "message": err.Error()})
+						}
+						return ctx.Status(http.StatusBadRequest).JSON(map[string]string{"status": "error", "message": err.Error()})
+					}
+
+					return ctx.Status(http.StatusAccepted).JSON(p)
+				},
+			)
+
+			app.Get(
+				"/api/price-plans", func(ctx *fiber.Ctx) error {
+					a, err := d.ListPricePlans(ctx.Context())
+					if err != nil {
+						return ctx.Status(http.StatusBadRequest).JSON(map[string]string{"status": "error", "message": err.Error()})
+					}
+
+					return ctx.Status(http.StatusOK).JSON(a)
+				},
+			)
+
+			app.Get(
+				"/api/price-plan", func(ctx *fiber.Ctx) error {
+					p, err := d.GetPricePlan(ctx.Context(), ctx.Query("id"))
+					if err != nil {
+						if errors.Is(err, domain.PricePlanNotFoundError) {
+							return ctx.Status(http.StatusNotFound).JSON(map[string]string{"status": "error", "message": err.Error()})
+						}
+						return ctx.Status(http.StatusBadRequest).JSON(map[string]string{"status": "error", "message": err.Error()})
+					}
+
+					return ctx.Status(http.StatusOK).JSON(p)
+				},
+			)
+
+			app.Delete(
+				"/api/price-plan", func(ctx *fiber.Ctx) error {
+					id := ctx.Query("id", "")
+
+					if id == "" {
+						return ctx.Status(http.StatusBadRequest).JSON(map[string]string{"status": "error", "message": "id is required"})
+					}
+
+					if err := d.DeletePricePlan(ctx.Context(), id); err != nil {
+						return ctx.Status(http.StatusBadRequest).JSON(map[string]string{"status": "error", "message": err.Error()})
+					}
+
+					return ctx.Status(http.StatusAccepted).JSON(map[string]string{"status": "ok"})
+				},
+			)
+
+			app.Get("/api/charge", func(ctx *fiber.Ctx) error {
+				query := domain.ChargeQuery{
+					PricePlanId: ctx.Query("plan"),
+					Subject:     ctx.Query("subject"),
+					Window:      entities.WindowSize(ctx.Query("window")),
+					At:          time.Now().UTC(),
+				}
+
+				if query.Subject == "" {
+					return ctx.Status(http.StatusBadRequest).JSON(map[string]string{"status": "error", "message": "subject is required"})
+				}
+
+				if at := ctx.Query("at"); at != "" {
+					var err error
+					if query.At, err = time.Parse(time.RFC3339, at); err != nil {
+						return ctx.Status(http.StatusBadRequest).JSON(map[string]string{"status": "error", "message": "at must be in RFC3339 format"})
+					}
+				}
+
+				charge, err := d.ComputeCharge(ctx.Context(), query)
+				if err != nil {
+					if errors.Is(err, domain.PricePlanNotFoundError) {
+						return ctx.Status(http.StatusNotFound).JSON(map[string]string{"status": "error", "message": err.Error()})
+					}
+					return ctx.Status(http.StatusBadRequest).JSON(map[string]string{"status": "error", "message": err.Error()})
+				}
+
+				return ctx.Status(http.StatusOK).JSON(charge)
+			})
+
+			app.Put(
+				"/api/limit", func(ctx *fiber.Ctx) error {
+					var limit entities.Limit
+
+					if err := ctx.BodyParser(&limit); err != nil {
+						return ctx.Status(http.StatusBadRequest).JSON(map[string]string{"status": "error", "message": err.Error()})
+					}
+
+					l, err := d.SetLimit(ctx.Context(), limit)
+					if err != nil {
+						return ctx.Status(http.StatusBadRequest).JSON(map[string]string{"status": "error", "message": err.Error()})
+					}
+
+					return ctx.Status(http.StatusAccepted).JSON(l)
+				},
+			)
+
+			app.Get(
+				"/api/limits", func(ctx *fiber.Ctx) error {
+					a, err := d.ListLimits(ctx.Context(), ctx.Query("meter"))
+					if err != nil {
+						return ctx.Status(http.StatusBadRequest).JSON(map[string]string{"status": "error", "message": err.Error()})
+					}
+
+					return ctx.Status(http.StatusOK).JSON(a)
+				},
+			)
+
+			app.Get(
+				"/api/limit", func(ctx *fiber.Ctx) error {
+					l, err := d.GetLimit(ctx.Context(), ctx.Query("meter"), ctx.Query("subject"))
+					if err != nil {
+						if errors.Is(err, domain.LimitNotFoundError) {
+							return ctx.Status(http.StatusNotFound).JSON(map[string]string{"status": "error", "message": err.Error()})
+						}
+						return ctx.Status(http.StatusBadRequest).JSON(map[string]string{"status": "error", "message": err.Error()})
+					}
+
+					return ctx.Status(http.StatusOK).JSON(l)
+				},
+			)
+
+			app.Delete(
+				"/api/limit", func(ctx *fiber.Ctx) error {
+					meter := ctx.Query("meter", "")
+
+					if meter == "" {
+						return ctx.Status(http.StatusBadRequest).JSON(map[string]string{"status": "error", "message": "meter is required"})
+					}
+
+					if err := d.DeleteLimit(ctx.Context(), meter, ctx.Query("subject")); err != nil {
+						return ctx.Status(http.StatusBadRequest).JSON(map[string]string{"status": "error", "message": err.Error()})
+					}
+
+					return ctx.Status(http.StatusAccepted).JSON(map[string]string{"status": "ok"})
+				},
+			)
+
+			app.Get("/api/entitlements/check", func(ctx *fiber.Ctx) error {
+				meter, subject := ctx.Query("meter"), ctx.Query("subject")
+				if meter == "" || subject == "" {
+					return ctx.Status(http.StatusBadRequest).JSON(map[string]string{"status": "error", "message": "meter and subject are required"})
+				}
+
+				var amount float64
+				if a := ctx.Query("amount"); a != "" {
+					var err error
+					if amount, err = strconv.ParseFloat(a, 64); err != nil || amount < 0 {
+						return ctx.Status(http.StatusBadRequest).JSON(map[string]string{"status": "error", "message": "amount must be a non negative number"})
+					}
+				}
+
+				entitlement, err := d.CheckEntitlement(ctx.Context(), meter, subject, amount)
+				if err != nil {
+					if errors.Is(err, domain.MeterNotFoundError) {
+						return ctx.Status(http.StatusNotFound).JSON(map[string]string{"status": "error", "message": err.Error()})
+					}
+					return ctx.Status(http.StatusBadRequest).JSON(map[string]string{"status": "error", "message": err.Error()})
+				}
+
+				return ctx.Status(http.StatusOK).JSON(entitlement)
+			})
+
+			app.Post(
+				"/api/create-alert-rule", func(ctx *fiber.Ctx) error {
+					var rule entities.AlertRule
+
+					if err := ctx.BodyParser(&rule); err != nil {
+						return ctx.Status(http.StatusBadRequest).JSON(map[string]string{"status": "error", "message": err.Error()})
+					}
+
+					r, err := d.CreateAlertRule(ctx.Context(), rule)
+					if err != nil {
+						return ctx.Status(http.StatusBadRequest).JSON(map[string]string{"status": "error", "message": err.Error()})
+					}
+
+					return ctx.Status(http.StatusAccepted).JSON(r)
+				},
+			)
+
+			app.Put(
+				"/api/alert-rule", func(ctx *fiber.Ctx) error {
+					var rule entities.AlertRule
+
+					if err := ctx.BodyParser(&rule); err != nil {
 						return ctx.Status(http.StatusBadRequest).JSON(map[string]string{"status": "error", "message": err.Error()})
 					}
 
-					d.RemoveMeterFromConsume(fmt.Sprintf("%x", md5.Sum([]byte(key))))
+					r, err := d.UpdateAlertRule(ctx.Context(), rule)
+					if err != nil {
+						if errors.Is(err, domain.AlertRuleNotFoundError) {
+							return ctx.Status(http.StatusNotFound).JSON(map[string]string{"status": "error", "message": err.Error()})
+						}
+						return ctx.Status(http.StatusBadRequest).JSON(map[string]string{"status": "error", "message": err.Error()})
+					}
+
+					return ctx.Status(http.StatusAccepted).JSON(r)
+				},
+			)
+
+			app.Get(
+				"/api/alert-rules", func(ctx *fiber.Ctx) error {
+					a, err := d.ListAlertRules(ctx.Context())
+					if err != nil {
+						return ctx.Status(http.StatusBadRequest).JSON(map[string]string{"status": "error", "message": err.Error()})
+					}
+
+					return ctx.Status(http.StatusOK).JSON(a)
+				},
+			)
+
+			app.Get(
+				"/api/alert-rule", func(ctx *fiber.Ctx) error {
+					r, err := d.GetAlertRule(ctx.Context(), ctx.Query("id"))
+					if err != nil {
+						if errors.Is(err, domain.AlertRuleNotFoundError) {
+							return ctx.Status(http.StatusNotFound).JSON(map[string]string{"status": "error", "message": err.Error()})
+						}
+						return ctx.Status(http.StatusBadRequest).JSON(map[string]string{"status": "error", "message": err.Error()})
+					}
+
+					return ctx.Status(http.StatusOK).JSON(r)
+				},
+			)
+
+			app.Delete(
+				"/api/alert-rule", func(ctx *fiber.Ctx) error {
+					id := ctx.Query("id", "")
+
+					if id == "" {
+						return ctx.Status(http.StatusBadRequest).JSON(map[string]string{"status": "error", "message": "id is required"})
+					}
+
+					if err := d.DeleteAlertRule(ctx.Context(), id); err != nil {
+						return ctx.Status(http.StatusBadRequest).JSON(map[string]string{"status": "error", "message": err.Error()})
+					}
 
 					return ctx.Status(http.StatusAccepted).JSON(map[string]string{"status": "ok"})
 				},
 			)
 
+			app.Get(
+				"/api/alert-deliveries", func(ctx *fiber.Ctx) error {
+					a, err := d.ListAlertDeliveries(ctx.Context(), ctx.Query("rule"))
+					if err != nil {
+						return ctx.Status(http.StatusBadRequest).JSON(map[string]string{"status": "error", "message": err.Error()})
+					}
+
+					return ctx.Status(http.StatusOK).JSON(a)
+				},
+			)
+
 			app.Post(
-				"/api/register-event", func(ctx *fiber.Ctx) error {
-					var event entities.Event
+				"/api/close-period", func(ctx *fiber.Ctx) error {
+					query, err := parsePeriodQuery(ctx)
+					if err != nil {
+						return ctx.Status(http.StatusBadRequest).JSON(map[string]string{"status": "error", "message": err.Error()})
+					}
 
-					m, err := d.ListMeters(ctx.Context())
-					if err != nil && err != jetstream.ErrKeyNotFound {
+					p, err := d.ClosePeriod(ctx.Context(), query)
+					if err != nil {
+						if errors.Is(err, domain.MeterNotFoundError) {
+							return ctx.Status(http.StatusNotFound).JSON(map[string]string{"status": "error", "message": err.Error()})
+						}
+						if errors.Is(err, domain.PeriodAlreadyClosedError) {
+							return ctx.Status(http.StatusConflict).JSON(map[string]string{"status": "error", "message": err.Error()})
+						}
 						return ctx.Status(http.StatusBadRequest).JSON(map[string]string{"status": "error", "message": err.Error()})
 					}
 
-					if len(m) == 0 {
-						return ctx.Status(http.StatusBadRequest).JSON(map[string]string{"status": "error", "message": "no meter found with provided event type"})
+					return ctx.Status(http.StatusAccepted).JSON(p)
+				},
+			)
+
+			app.Get(
+				"/api/closed-periods", func(ctx *fiber.Ctx) error {
+					p, err := d.ListClosedPeriods(ctx.Context(), ctx.Query("meter"))
+					if err != nil {
+						return ctx.Status(http.StatusBadRequest).JSON(map[string]string{"status": "error", "message": err.Error()})
+					}
+
+					return ctx.Status(http.StatusOK).JSON(p)
+				},
+			)
+
+			app.Get(
+				"/api/snapshot", func(ctx *fiber.Ctx) error {
+					query, err := parsePeriodQuery(ctx)
+					if err != nil {
+						return ctx.Status(http.StatusBadRequest).JSON(map[string]string{"status": "error", "message": err.Error()})
+					}
+
+					s, err := d.GetSnapshot(ctx.Context(), query)
+					if err != nil {
+						if errors.Is(err, domain.PeriodNotFoundError) {
+							return ctx.Status(http.StatusNotFound).JSON(map[string]string{"status": "error", "message": err.Error()})
+						}
+						return ctx.Status(http.StatusBadRequest).JSON(map[string]string{"status": "error", "message": err.Error()})
+					}
+
+					return ctx.Status(http.StatusOK).JSON(s)
+				},
+			)
+
+			app.Get(
+				"/api/adjustments", func(ctx *fiber.Ctx) error {
+					query, err := parsePeriodQuery(ctx)
+					if err != nil {
+						return ctx.Status(http.StatusBadRequest).JSON(map[string]string{"status": "error", "message": err.Error()})
+					}
+
+					a, err := d.ListAdjustments(ctx.Context(), query)
+					if err != nil {
+						if errors.Is(err, domain.PeriodNotFoundError) {
+							return ctx.Status(http.StatusNotFound).JSON(map[string]string{"status": "error", "message": err.Error()})
+						}
+						return ctx.Status(http.StatusBadRequest).JSON(map[string]string{"status": "error", "message": err.Error()})
+					}
+
+					return ctx.Status(http.StatusOK).JSON(a)
+				},
+			)
+
+			app.Post(
+				"/api/create-event-type", func(ctx *fiber.Ctx) error {
+					var eventType entities.EventType
+
+					if err := ctx.BodyParser(&eventType); err != nil {
+						return ctx.Status(http.StatusBadRequest).JSON(map[string]string{"status": "error", "message": err.Error()})
+					}
+
+					et, err := d.RegisterEventType(ctx.Context(), eventType)
+					if err != nil {
+						return ctx.Status(http.StatusBadRequest).JSON(map[string]string{"status": "error", "message": err.Error()})
+					}
+
+					return ctx.Status(http.StatusAccepted).JSON(et)
+				},
+			)
+
+			app.Put(
+				"/api/event-type", func(ctx *fiber.Ctx) error {
+					var eventType entities.EventType
+
+					if err := ctx.BodyParser(&eventType); err != nil {
+						return ctx.Status(http.StatusBadRequest).JSON(map[string]string{"status": "error", "message": err.Error()})
+					}
+
+					et, err := d.UpdateEventType(ctx.Context(), eventType)
+					if err != nil {
+						if errors.Is(err, domain.EventTypeNotFoundError) {
+							return ctx.Status(http.StatusNotFound).JSON(map[string]string{"status": "error", "message": err.Error()})
+						}
+						return ctx.Status(http.StatusBadRequest).JSON(map[string]string{"status": "error", "message": err.Error()})
 					}
 
-					if err := ctx.BodyParser(&event); err != nil {
+					return ctx.Status(http.StatusAccepted).JSON(et)
+				},
+			)
+
+			app.Get(
+				"/api/event-types", func(ctx *fiber.Ctx) error {
+					a, err := d.ListEventTypes(ctx.Context())
+					if err != nil {
 						return ctx.Status(http.StatusBadRequest).JSON(map[string]string{"status": "error", "message": err.Error()})
 					}
 
+					return ctx.Status(http.StatusOK).JSON(a)
+				},
+			)
+
+			app.Get(
+				"/api/event-type/versions", func(ctx *fiber.Ctx) error {
+					a, err := d.ListEventTypeVersions(ctx.Context(), ctx.Query("name"))
+					if err != nil {
+						return ctx.Status(http.StatusBadRequest).JSON(map[string]string{"status": "error", "message": err.Error()})
+					}
+
+					return ctx.Status(http.StatusOK).JSON(a)
+				},
+			)
+
+			app.Get(
+				"/api/event-type", func(ctx *fiber.Ctx) error {
+					et, err := d.GetEventType(ctx.Context(), ctx.Query("name"))
+					if err != nil {
+						if errors.Is(err, domain.EventTypeNotFoundError) {
+							return ctx.Status(http.StatusNotFound).JSON(map[string]string{"status": "error", "message": err.Error()})
+						}
+						return ctx.Status(http.StatusBadRequest).JSON(map[string]string{"status": "error", "message": err.Error()})
+					}
+
+					return ctx.Status(http.StatusOK).JSON(et)
+				},
+			)
+
+			app.Delete(
+				"/api/event-type", func(ctx *fiber.Ctx) error {
+					name := ctx.Query("name", "")
+
+					if name == "" {
+						return ctx.Status(http.StatusBadRequest).JSON(map[string]string{"status": "error", "message": "name is required"})
+					}
+
+					if err := d.DeleteEventType(ctx.Context(), name); err != nil {
+						return ctx.Status(http.StatusBadRequest).JSON(map[string]string{"status": "error", "message": err.Error()})
+					}
+
+					return ctx.Status(http.StatusAccepted).JSON(map[string]string{"status": "ok"})
+				},
+			)
+
+			app.Post(
+				"/api/register-event", func(ctx *fiber.Ctx) error {
+					eventTypes, err := meteredEventTypes(ctx.Context(), d)
+					if err != nil {
+						return ctx.Status(http.StatusBadRequest).JSON(map[string]string{"status": "error", "message": err.Error()})
+					}
+
+					event, err := parseEvent(ctx)
+					if err != nil {
+						recordEvent("", eventRejected)
+						return ctx.Status(http.StatusBadRequest).JSON(map[string]string{"status": "error", "message": err.Error()})
+					}
+
+					if event.Time == "" {
+						event.Time = time.Now().UTC().Format(time.RFC3339Nano)
+					}
+
 					if err := event.IsValid(); err != nil {
+						recordEvent("", eventRejected)
+						return ctx.Status(http.StatusBadRequest).JSON(map[string]string{"status": "error", "message": err.Error()})
+					}
+
+					// the same rule as for batches, events of types that no meter aggregates are rejected
+					if !eventTypes[event.EventType] {
+						recordEvent("", eventRejected)
+						return ctx.Status(http.StatusBadRequest).JSON(map[string]string{"status": "error", "message": "no meter found with provided event type"})
+					}
+
+					if err := d.ValidateEvent(ctx.Context(), event); err != nil {
+						recordEvent(event.EventType, eventRejected)
 						return ctx.Status(http.StatusBadRequest).JSON(map[string]string{"status": "error", "message": err.Error()})
 					}
 
 					b, err := event.ToJson()
 					if err != nil {
+						recordEvent(event.EventType, eventRejected)
 						return ctx.Status(http.StatusBadRequest).JSON(map[string]string{"status": "error", "message": err.Error()})
 					}
 
@@ -174,13 +877,58 @@ var Module = fx.Module("app",
 						Payload: b,
 						MsgID:   functions.New(event.Id),
 					}); err != nil {
+						recordEvent(event.EventType, eventRejected)
 						return ctx.Status(http.StatusBadRequest).JSON(map[string]string{"status": "error", "message": err.Error()})
 					}
 
+					recordEvent(event.EventType, eventAccepted)
 					return ctx.Status(http.StatusAccepted).JSON(map[string]string{"status": "ok"})
 				},
 			)
 
+			app.Post(
+				"/api/events\\:batch", func(ctx *fiber.Ctx) error {
+					events, err := parseEventsBatch(ctx.Body(), ctx.Get(fiber.HeaderContentType), ev.EventsBatchMaxSize)
+					if err != nil {
+						return ctx.Status(http.StatusBadRequest).JSON(map[string]string{"status": "error", "message": err.Error()})
+					}
+
+					eventTypes, err := meteredEventTypes(ctx.Context(), d)
+					if err != nil {
+						return ctx.Status(http.StatusBadRequest).JSON(map[string]string{"status": "error", "message": err.Error()})
+					}
+
+					results := registerEvents(ctx.Context(), d, mp, eventTypes, events)
+					return ctx.Status(http.StatusAccepted).JSON(newEventsBatchResult(results))
+				},
+			)
+
+			// OTLP/HTTP metrics receiver, at the path OTLP exporters default to
+			app.Post("/v1/metrics", func(ctx *fiber.Ctx) error {
+				contentType := mediaType(ctx.Get(fiber.HeaderContentType))
+
+				req, err := parseOtlpMetrics(ctx.Body(), contentType)
+				if err != nil {
+					return otlpError(ctx, contentType, http.StatusBadRequest, codes.InvalidArgument, err)
+				}
+
+				eventTypes, err := meteredEventTypes(ctx.Context(), d)
+				if err != nil {
+					// OTLP exporters retry on 503
+					return otlpError(ctx, contentType, http.StatusServiceUnavailable, codes.Unavailable, err)
+				}
+
+				events := otlpEvents(req, ev.OtlpSubjectAttribute, eventTypes, func(series string, point *entities.CumulativePoint, monotonic bool) (map[string]float64, bool, error) {
+					return d.CumulativeDelta(ctx.Context(), series, point, monotonic)
+				})
+				if len(events) > ev.EventsBatchMaxSize {
+					return otlpError(ctx, contentType, http.StatusBadRequest, codes.InvalidArgument, fmt.Errorf("export has %d metered data points, at most %d are allowed", len(events), ev.EventsBatchMaxSize))
+				}
+
+				results := registerEvents(ctx.Context(), d, mp, eventTypes, events)
+				return otlpResponse(ctx, contentType, http.StatusOK, newOtlpMetricsResponse(results))
+			})
+
 			app.Get("/healthy", func(ctx *fiber.Ctx) error {
 				return ctx.Status(http.StatusOK).Send([]byte("OK"))
 			})
@@ -0,0 +1,69 @@
+package app
+
+import (
+	"encoding/json"
+	"mime"
+	"net/url"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kloudlite/kloudmeter/internal/domain/entities"
+)
+
+const (
+	contentTypeCloudEvents      = "application/cloudevents+json"
+	contentTypeCloudEventsBatch = "application/cloudevents-batch+json"
+)
+
+func mediaType(contentType string) string {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return contentType
+	}
+	return mt
+}
+
+// ceHeader reads a binary mode cloudevent attribute, header values are percent encoded by the spec
+func ceHeader(ctx *fiber.Ctx, attribute string) string {
+	v := ctx.Get("ce-" + attribute)
+	if s, err := url.PathUnescape(v); err == nil {
+		return s
+	}
+	return v
+}
+
+// binaryCloudEvent reads a binary mode cloudevent, attributes are in ce-* headers and the body is its data
+func binaryCloudEvent(ctx *fiber.Ctx) *entities.CloudEvent {
+	return &entities.CloudEvent{
+		SpecVersion:     ceHeader(ctx, "specversion"),
+		Id:              ceHeader(ctx, "id"),
+		Source:          ceHeader(ctx, "source"),
+		Type:            ceHeader(ctx, "type"),
+		Subject:         ceHeader(ctx, "subject"),
+		Time:            ceHeader(ctx, "time"),
+		DataSchema:      ceHeader(ctx, "dataschema"),
+		DataContentType: ctx.Get(fiber.HeaderContentType),
+		Data:            json.RawMessage(append([]byte{}, ctx.Body()...)),
+	}
+}
+
+// parseEvent reads the event of a request, which is either in kloudmeter's own JSON format,
+// or a CloudEvent in structured (application/cloudevents+json) or binary (ce-* headers) mode
+func parseEvent(ctx *fiber.Ctx) (*entities.Event, error) {
+	if ctx.Get("ce-specversion") != "" {
+		return binaryCloudEvent(ctx).ToEvent()
+	}
+
+	if mediaType(ctx.Get(fiber.HeaderContentType)) == contentTypeCloudEvents {
+		var ce entities.CloudEvent
+		if err := json.Unmarshal(ctx.Body(), &ce); err != nil {
+			return nil, err
+		}
+		return ce.ToEvent()
+	}
+
+	var event entities.Event
+	if err := ctx.BodyParser(&event); err != nil {
+		return nil, err
+	}
+	return &event, nil
+}
@@ -0,0 +1,237 @@
+package app
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/kloudlite/kloudmeter/internal/domain"
+	"github.com/kloudlite/kloudmeter/internal/domain/entities"
+	"github.com/kloudlite/kloudmeter/pkg/errors"
+	"github.com/kloudlite/kloudmeter/pkg/functions"
+	"github.com/kloudlite/kloudmeter/pkg/messaging/types"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+type eventStatus string
+
+const (
+	eventAccepted  eventStatus = "accepted"
+	eventRejected  eventStatus = "rejected"
+	eventDuplicate eventStatus = "duplicate"
+)
+
+// eventResult is the outcome of a single event of a batch, Index is its position in the request body
+type eventResult struct {
+	Index   int         `json:"index"`
+	Id      string      `json:"id,omitempty"`
+	Status  eventStatus `json:"status"`
+	Message string      `json:"message,omitempty"`
+
+	// eventType is set once the event is valid and metered, to count accepted and rejected events per event type
+	eventType string
+}
+
+type eventsBatchResult struct {
+	Status     string        `json:"status"`
+	Accepted   int           `json:"accepted"`
+	Rejected   int           `json:"rejected"`
+	Duplicates int           `json:"duplicates"`
+	Results    []eventResult `json:"results"`
+}
+
+func newEventsBatchResult(results []eventResult) *eventsBatchResult {
+	r := &eventsBatchResult{Status: "ok", Results: results}
+	for _, result := range results {
+		switch result.Status {
+		case eventAccepted:
+			r.Accepted++
+		case eventRejected:
+			r.Rejected++
+		case eventDuplicate:
+			r.Duplicates++
+		}
+	}
+	return r
+}
+
+// batchEvent is an event of a batch, or the error it failed to parse with
+type batchEvent struct {
+	event *entities.Event
+	err   error
+}
+
+// parseEventsBatch reads events from a JSON array, newline delimited JSON (blank lines are skipped),
+// or a CloudEvents JSON batch (application/cloudevents-batch+json).
+// Events that fail to parse are returned with their error, so that the rest of the batch still goes through
+func parseEventsBatch(body []byte, contentType string, maxSize int) ([]batchEvent, error) {
+	var raw []json.RawMessage
+
+	switch mediaType(contentType) {
+	case "application/x-ndjson", "application/ndjson":
+		scanner := bufio.NewScanner(bytes.NewReader(body))
+		scanner.Buffer(make([]byte, 64*1024), len(body)+1)
+		for scanner.Scan() {
+			line := bytes.TrimSpace(scanner.Bytes())
+			if len(line) == 0 {
+				continue
+			}
+			raw = append(raw, json.RawMessage(append([]byte{}, line...)))
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	default:
+		if err := json.Unmarshal(body, &raw); err != nil {
+			return nil, fmt.Errorf("body must be a JSON array of events: %w", err)
+		}
+	}
+
+	if len(raw) == 0 {
+		return nil, fmt.Errorf("batch has no events")
+	}
+
+	if len(raw) > maxSize {
+		return nil, fmt.Errorf("batch has %d events, at most %d are allowed", len(raw), maxSize)
+	}
+
+	events := make([]batchEvent, 0, len(raw))
+	cloudEvents := mediaType(contentType) == contentTypeCloudEventsBatch
+	for _, r := range raw {
+		if cloudEvents {
+			var ce entities.CloudEvent
+			if err := json.Unmarshal(r, &ce); err != nil {
+				events = append(events, batchEvent{err: err})
+				continue
+			}
+			event, err := ce.ToEvent()
+			events = append(events, batchEvent{event: event, err: err})
+			continue
+		}
+
+		var event entities.Event
+		if err := json.Unmarshal(r, &event); err != nil {
+			events = append(events, batchEvent{err: err})
+			continue
+		}
+		events = append(events, batchEvent{event: &event})
+	}
+
+	return events, nil
+}
+
+// meteredEventTypes returns the event types that at least one meter aggregates
+func meteredEventTypes(ctx context.Context, d domain.Domain) (map[string]bool, error) {
+	m, err := d.ListMeters(ctx)
+	if err != nil && err != jetstream.ErrKeyNotFound {
+		return nil, err
+	}
+
+	eventTypes := make(map[string]bool, len(m))
+	for _, meter := range m {
+		eventTypes[meter.Value.EventType] = true
+	}
+	return eventTypes, nil
+}
+
+// registerEvents validates the events of a batch, and publishes the valid ones to meters.events.*, awaiting their acks.
+// It returns the outcome of every event, in order
+func registerEvents(ctx context.Context, d domain.Domain, mp domain.MeterProducer, eventTypes map[string]bool, events []batchEvent) []eventResult {
+	// acks are awaited for every published event, bounded by this timeout, or until the request is done
+	pctx, cf := context.WithTimeout(ctx, 30*time.Second)
+	defer cf()
+
+	// event types are looked up once per batch, nil for event types without a registered schema
+	registered := make(map[string]*entities.EventType)
+
+	results := make([]eventResult, len(events))
+	pending := make(map[int]<-chan types.ProduceResult, len(events))
+	seen := make(map[string]bool, len(events))
+
+	for i, be := range events {
+		results[i] = eventResult{Index: i, Status: eventRejected}
+		if be.err != nil {
+			results[i].Message = be.err.Error()
+			continue
+		}
+
+		event := be.event
+		results[i].Id = event.Id
+
+		if event.Time == "" {
+			event.Time = time.Now().UTC().Format(time.RFC3339Nano)
+		}
+
+		if err := event.IsValid(); err != nil {
+			results[i].Message = err.Error()
+			continue
+		}
+		if !eventTypes[event.EventType] {
+			results[i].Message = "no meter found with provided event type"
+			continue
+		}
+		results[i].eventType = event.EventType
+
+		eventType, ok := registered[event.EventType]
+		if !ok {
+			et, err := d.GetEventType(ctx, event.EventType)
+			if err != nil && !errors.Is(err, domain.EventTypeNotFoundError) {
+				results[i].Message = err.Error()
+				continue
+			}
+			eventType = et
+			registered[event.EventType] = et
+		}
+
+		if err := d.ValidateEventData(eventType, event); err != nil {
+			results[i].Message = err.Error()
+			continue
+		}
+
+		if seen[event.Key()] {
+			results[i].Status = eventDuplicate
+			results[i].Message = "event already present in the batch"
+			continue
+		}
+		seen[event.Key()] = true
+
+		b, err := event.ToJson()
+		if err != nil {
+			results[i].Message = err.Error()
+			continue
+		}
+
+		ch, err := mp.ProduceAsync(pctx, types.ProduceMsg{
+			Subject: fmt.Sprintf("meters.events.%s", event.Key()),
+			Payload: b,
+			MsgID:   functions.New(event.Id),
+		})
+		if err != nil {
+			results[i].Message = err.Error()
+			continue
+		}
+		pending[i] = ch
+	}
+
+	for i, ch := range pending {
+		r := <-ch
+		switch {
+		case r.Err != nil:
+			results[i].Message = r.Err.Error()
+		case r.Duplicate:
+			results[i].Status = eventDuplicate
+			results[i].Message = "event has already been registered"
+		default:
+			results[i].Status = eventAccepted
+		}
+	}
+
+	for _, r := range results {
+		recordEvent(r.eventType, r.Status)
+	}
+
+	return results
+}
@@ -0,0 +1,52 @@
+package app
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	eventsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "kloudmeter_events_total",
+		Help: "Events received by the API, by event type and status (accepted, rejected or duplicate)",
+	}, []string{"event_type", "status"})
+
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "kloudmeter_http_request_duration_seconds",
+		Help:    "Latency of API requests, by method, route and status code",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+)
+
+// unknownEventType labels the events whose type no meter aggregates, or that were rejected before their type was known
+const unknownEventType = "unknown"
+
+// recordEvent counts an event received by the API. Callers pass an empty event type unless it is metered, so that clients
+// can not add series with made up event types.
+// Label values are cloned, as strings read from fiber requests (e.g. binary CloudEvents headers) are reused after the request
+func recordEvent(eventType string, status eventStatus) {
+	if eventType == "" {
+		eventType = unknownEventType
+	}
+	eventsTotal.WithLabelValues(strings.Clone(eventType), string(status)).Inc()
+}
+
+// instrumentRequests observes the latency of every request, labelled with its route pattern (not its path),
+// so that query params and path params do not add series
+func instrumentRequests(ctx *fiber.Ctx) error {
+	start := time.Now()
+	err := ctx.Next()
+
+	status := ctx.Response().StatusCode()
+	if e, ok := err.(*fiber.Error); ok {
+		status = e.Code
+	}
+
+	httpRequestDuration.WithLabelValues(strings.Clone(ctx.Method()), ctx.Route().Path, strconv.Itoa(status)).Observe(time.Since(start).Seconds())
+	return err
+}
@@ -0,0 +1,348 @@
+package app
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/kloudlite/kloudmeter/internal/domain/entities"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	contentTypeProtobuf = "application/x-protobuf"
+	contentTypeJSON     = "application/json"
+)
+
+// otlpTokenSanitiser replaces the characters of metric names and subjects, that event types and subjects do not allow
+var otlpTokenSanitiser = regexp.MustCompile(`[^a-zA-Z0-9-_]`)
+
+// parseOtlpMetrics reads an OTLP/HTTP metrics export request, encoded as binary protobuf or as protobuf JSON
+func parseOtlpMetrics(body []byte, contentType string) (*colmetricspb.ExportMetricsServiceRequest, error) {
+	var req colmetricspb.ExportMetricsServiceRequest
+
+	switch contentType {
+	case contentTypeProtobuf:
+		if err := proto.Unmarshal(body, &req); err != nil {
+			return nil, fmt.Errorf("invalid OTLP protobuf body: %w", err)
+		}
+	case contentTypeJSON:
+		if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(body, &req); err != nil {
+			return nil, fmt.Errorf("invalid OTLP JSON body: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported content type %q, must be %s or %s", contentType, contentTypeProtobuf, contentTypeJSON)
+	}
+
+	return &req, nil
+}
+
+// otlpValue maps an attribute value to its JSON equivalent, so that meters read it the same as event data
+func otlpValue(v *commonpb.AnyValue) any {
+	switch v := v.GetValue().(type) {
+	case *commonpb.AnyValue_StringValue:
+		return v.StringValue
+	case *commonpb.AnyValue_BoolValue:
+		return v.BoolValue
+	case *commonpb.AnyValue_IntValue:
+		return float64(v.IntValue)
+	case *commonpb.AnyValue_DoubleValue:
+		return v.DoubleValue
+	case *commonpb.AnyValue_BytesValue:
+		return base64.StdEncoding.EncodeToString(v.BytesValue)
+	case *commonpb.AnyValue_ArrayValue:
+		values := make([]any, 0, len(v.ArrayValue.GetValues()))
+		for _, item := range v.ArrayValue.GetValues() {
+			values = append(values, otlpValue(item))
+		}
+		return values
+	case *commonpb.AnyValue_KvlistValue:
+		return otlpAttributes(v.KvlistValue.GetValues())
+	}
+	return nil
+}
+
+func otlpAttributes(kvs []*commonpb.KeyValue) map[string]any {
+	attributes := make(map[string]any, len(kvs))
+	for _, kv := range kvs {
+		attributes[kv.GetKey()] = otlpValue(kv.GetValue())
+	}
+	return attributes
+}
+
+func otlpTemporality(t metricspb.AggregationTemporality) string {
+	switch t {
+	case metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA:
+		return "delta"
+	case metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE:
+		return "cumulative"
+	}
+	return "unspecified"
+}
+
+// otlpDataPoint is a data point of a sum, gauge or histogram metric, in the shape of its event data. Points of
+// cumulative sums and histograms are converted to deltas before they become events
+type otlpDataPoint struct {
+	attributes []*commonpb.KeyValue
+	startTime  uint64
+	time       uint64
+	flags      uint32
+	data       map[string]any
+	cumulative bool
+	monotonic  bool
+}
+
+// otlpDeltaFunc converts a point of a cumulative series into the deltas of its values, see domain.CumulativeDelta
+type otlpDeltaFunc func(series string, point *entities.CumulativePoint, monotonic bool) (map[string]float64, bool, error)
+
+// otlpTotals returns the running totals of a cumulative point by name: the value of sums, and the count, sum and
+// bucket counts of histograms
+func otlpTotals(dp otlpDataPoint) map[string]float64 {
+	totals := map[string]float64{}
+	for _, name := range []string{"value", "count", "sum"} {
+		if v, ok := dp.data[name].(float64); ok {
+			totals[name] = v
+		}
+	}
+	if buckets, ok := dp.data["bucketCounts"].([]any); ok {
+		for i, c := range buckets {
+			totals[fmt.Sprintf("bucket.%d", i)] = c.(float64)
+		}
+	}
+	return totals
+}
+
+// otlpApplyDeltas replaces the totals of the point's data with their deltas. The total of sums is kept as total,
+// while the min and max of histograms are dropped, as they can not be converted
+func otlpApplyDeltas(dp otlpDataPoint, deltas map[string]float64) {
+	if v, ok := dp.data["value"]; ok {
+		dp.data["total"] = v
+	}
+	for _, name := range []string{"value", "count", "sum"} {
+		if _, ok := dp.data[name]; ok {
+			dp.data[name] = deltas[name]
+		}
+	}
+	if buckets, ok := dp.data["bucketCounts"].([]any); ok {
+		for i := range buckets {
+			buckets[i] = deltas[fmt.Sprintf("bucket.%d", i)]
+		}
+	}
+	delete(dp.data, "min")
+	delete(dp.data, "max")
+	dp.data["temporality"] = "delta"
+}
+
+func otlpDataPoints(metric *metricspb.Metric) ([]otlpDataPoint, error) {
+	var points []otlpDataPoint
+
+	number := func(dp *metricspb.NumberDataPoint, data map[string]any) otlpDataPoint {
+		switch v := dp.GetValue().(type) {
+		case *metricspb.NumberDataPoint_AsDouble:
+			data["value"] = v.AsDouble
+		case *metricspb.NumberDataPoint_AsInt:
+			data["value"] = float64(v.AsInt)
+		}
+		return otlpDataPoint{attributes: dp.GetAttributes(), startTime: dp.GetStartTimeUnixNano(), time: dp.GetTimeUnixNano(), flags: dp.GetFlags(), data: data}
+	}
+
+	switch m := metric.GetData().(type) {
+	case *metricspb.Metric_Gauge:
+		for _, dp := range m.Gauge.GetDataPoints() {
+			points = append(points, number(dp, map[string]any{}))
+		}
+
+	case *metricspb.Metric_Sum:
+		for _, dp := range m.Sum.GetDataPoints() {
+			point := number(dp, map[string]any{
+				"temporality": otlpTemporality(m.Sum.GetAggregationTemporality()),
+				"monotonic":   m.Sum.GetIsMonotonic(),
+			})
+			point.cumulative = m.Sum.GetAggregationTemporality() == metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE
+			point.monotonic = m.Sum.GetIsMonotonic()
+			points = append(points, point)
+		}
+
+	case *metricspb.Metric_Histogram:
+		for _, dp := range m.Histogram.GetDataPoints() {
+			data := map[string]any{
+				"temporality": otlpTemporality(m.Histogram.GetAggregationTemporality()),
+				"count":       float64(dp.GetCount()),
+			}
+			if dp.Sum != nil {
+				data["sum"] = dp.GetSum()
+			}
+			if dp.Min != nil {
+				data["min"] = dp.GetMin()
+			}
+			if dp.Max != nil {
+				data["max"] = dp.GetMax()
+			}
+
+			bucketCounts := make([]any, 0, len(dp.GetBucketCounts()))
+			for _, c := range dp.GetBucketCounts() {
+				bucketCounts = append(bucketCounts, float64(c))
+			}
+			explicitBounds := make([]any, 0, len(dp.GetExplicitBounds()))
+			for _, b := range dp.GetExplicitBounds() {
+				explicitBounds = append(explicitBounds, b)
+			}
+			data["bucketCounts"] = bucketCounts
+			data["explicitBounds"] = explicitBounds
+
+			points = append(points, otlpDataPoint{
+				attributes: dp.GetAttributes(), startTime: dp.GetStartTimeUnixNano(), time: dp.GetTimeUnixNano(), flags: dp.GetFlags(), data: data,
+				cumulative: m.Histogram.GetAggregationTemporality() == metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+				monotonic:  true,
+			})
+		}
+
+	default:
+		return nil, fmt.Errorf("metric %s is not a sum, gauge or histogram, which are the only metric types supported", metric.GetName())
+	}
+
+	return points, nil
+}
+
+func otlpDataPointsCount(metric *metricspb.Metric) int {
+	switch m := metric.GetData().(type) {
+	case *metricspb.Metric_ExponentialHistogram:
+		return len(m.ExponentialHistogram.GetDataPoints())
+	case *metricspb.Metric_Summary:
+		return len(m.Summary.GetDataPoints())
+	}
+	return 1
+}
+
+// otlpEvents converts the data points of sum, gauge and histogram metrics into events, the metric name becomes the
+// event type, and the resource's subjectAttribute its subject. Metrics that no meter aggregates, data points
+// without a recorded value, and cumulative points that toDelta does not count, are skipped.
+// Event ids are derived from the data point's series and timestamps, so that retried exports are deduplicated
+func otlpEvents(req *colmetricspb.ExportMetricsServiceRequest, subjectAttribute string, eventTypes map[string]bool, toDelta otlpDeltaFunc) []batchEvent {
+	var events []batchEvent
+
+	for _, rm := range req.GetResourceMetrics() {
+		resource := otlpAttributes(rm.GetResource().GetAttributes())
+		resourceJson, _ := json.Marshal(resource)
+
+		subject, _ := resource[subjectAttribute].(string)
+		subject = otlpTokenSanitiser.ReplaceAllString(subject, "_")
+
+		for _, sm := range rm.GetScopeMetrics() {
+			for _, metric := range sm.GetMetrics() {
+				eventType := otlpTokenSanitiser.ReplaceAllString(metric.GetName(), "_")
+				if !eventTypes[eventType] {
+					continue
+				}
+
+				points, err := otlpDataPoints(metric)
+				if err != nil {
+					// rejected data points are counted one by one in the export response
+					for i := 0; i < otlpDataPointsCount(metric); i++ {
+						events = append(events, batchEvent{err: err})
+					}
+					continue
+				}
+
+				for _, dp := range points {
+					if dp.flags&uint32(metricspb.DataPointFlags_DATA_POINT_FLAGS_NO_RECORDED_VALUE_MASK) != 0 {
+						continue
+					}
+
+					if subject == "" {
+						events = append(events, batchEvent{err: fmt.Errorf("resource has no %s attribute, which is the subject of its events", subjectAttribute)})
+						continue
+					}
+
+					attributes := otlpAttributes(dp.attributes)
+					attributesJson, _ := json.Marshal(attributes)
+
+					if dp.cumulative {
+						series := fmt.Sprintf("%x", md5.Sum([]byte(fmt.Sprintf("%s/%s/%s", metric.GetName(), resourceJson, attributesJson))))
+						deltas, ok, err := toDelta(series, &entities.CumulativePoint{StartTime: dp.startTime, Time: dp.time, Values: otlpTotals(dp)}, dp.monotonic)
+						if err != nil {
+							events = append(events, batchEvent{err: err})
+							continue
+						}
+						if !ok {
+							continue
+						}
+						otlpApplyDeltas(dp, deltas)
+					}
+
+					event := &entities.Event{
+						Id:        uuid.NewSHA1(uuid.NameSpaceOID, []byte(fmt.Sprintf("%s/%s/%s/%d/%d", metric.GetName(), resourceJson, attributesJson, dp.startTime, dp.time))).String(),
+						EventType: eventType,
+						Subject:   subject,
+						Data:      dp.data,
+					}
+
+					if dp.time != 0 {
+						event.Time = time.Unix(0, int64(dp.time)).UTC().Format(time.RFC3339Nano)
+					}
+
+					event.Data["unit"] = metric.GetUnit()
+					event.Data["attributes"] = attributes
+					event.Data["resource"] = resource
+
+					events = append(events, batchEvent{event: event})
+				}
+			}
+		}
+	}
+
+	return events
+}
+
+// newOtlpMetricsResponse reports rejected data points as a partial success, duplicates of registered events are not rejected
+func newOtlpMetricsResponse(results []eventResult) *colmetricspb.ExportMetricsServiceResponse {
+	resp := &colmetricspb.ExportMetricsServiceResponse{}
+	for _, r := range results {
+		if r.Status != eventRejected {
+			continue
+		}
+
+		if resp.PartialSuccess == nil {
+			resp.PartialSuccess = &colmetricspb.ExportMetricsPartialSuccess{ErrorMessage: r.Message}
+		}
+		resp.PartialSuccess.RejectedDataPoints++
+	}
+	return resp
+}
+
+// otlpResponse writes msg in the encoding of the request, as OTLP/HTTP requires. Requests of unsupported content types get protobuf
+func otlpResponse(ctx *fiber.Ctx, contentType string, statusCode int, msg proto.Message) error {
+	if contentType != contentTypeJSON {
+		contentType = contentTypeProtobuf
+	}
+
+	var b []byte
+	var err error
+	if contentType == contentTypeJSON {
+		b, err = protojson.Marshal(msg)
+	} else {
+		b, err = proto.Marshal(msg)
+	}
+	if err != nil {
+		return err
+	}
+
+	ctx.Set(fiber.HeaderContentType, contentType)
+	return ctx.Status(statusCode).Send(b)
+}
+
+// otlpError writes err as a google.rpc.Status, the error body of OTLP/HTTP
+func otlpError(ctx *fiber.Ctx, contentType string, statusCode int, code codes.Code, err error) error {
+	return otlpResponse(ctx, contentType, statusCode, &status.Status{Code: int32(code), Message: err.Error()})
+}
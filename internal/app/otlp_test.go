@@ -0,0 +1,185 @@
+package app
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/kloudlite/kloudmeter/internal/domain/entities"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+)
+
+func otlpStringAttribute(key string, value string) *commonpb.KeyValue {
+	return &commonpb.KeyValue{Key: key, Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: value}}}
+}
+
+func otlpRequest(subject string, metrics ...*metricspb.Metric) *colmetricspb.ExportMetricsServiceRequest {
+	resource := &resourcepb.Resource{}
+	if subject != "" {
+		resource.Attributes = []*commonpb.KeyValue{otlpStringAttribute("service.name", subject)}
+	}
+	return &colmetricspb.ExportMetricsServiceRequest{ResourceMetrics: []*metricspb.ResourceMetrics{{
+		Resource:     resource,
+		ScopeMetrics: []*metricspb.ScopeMetrics{{Metrics: metrics}},
+	}}}
+}
+
+func otlpSum(name string, temporality metricspb.AggregationTemporality, values ...float64) *metricspb.Metric {
+	points := make([]*metricspb.NumberDataPoint, 0, len(values))
+	for i, v := range values {
+		points = append(points, &metricspb.NumberDataPoint{
+			Attributes:        []*commonpb.KeyValue{otlpStringAttribute("route", "/api")},
+			StartTimeUnixNano: 1,
+			TimeUnixNano:      uint64(10 + i),
+			Value:             &metricspb.NumberDataPoint_AsDouble{AsDouble: v},
+		})
+	}
+	return &metricspb.Metric{Name: name, Data: &metricspb.Metric_Sum{Sum: &metricspb.Sum{
+		AggregationTemporality: temporality,
+		IsMonotonic:            true,
+		DataPoints:             points,
+	}}}
+}
+
+// halfDeltas counts half of every total as its delta, and skips points whose value is 0
+func halfDeltas(_ string, point *entities.CumulativePoint, _ bool) (map[string]float64, bool, error) {
+	if v, ok := point.Values["value"]; ok && v == 0 {
+		return nil, false, nil
+	}
+	deltas := make(map[string]float64, len(point.Values))
+	for name, v := range point.Values {
+		deltas[name] = v / 2
+	}
+	return deltas, true, nil
+}
+
+func TestOtlpEvents(t *testing.T) {
+	cumulative := metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE
+	delta := metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA
+
+	histogram := &metricspb.Metric{Name: "latency", Data: &metricspb.Metric_Histogram{Histogram: &metricspb.Histogram{
+		AggregationTemporality: cumulative,
+		DataPoints: []*metricspb.HistogramDataPoint{{
+			StartTimeUnixNano: 1,
+			TimeUnixNano:      10,
+			Count:             4,
+			Sum:               func() *float64 { v := 8.0; return &v }(),
+			Min:               func() *float64 { v := 1.0; return &v }(),
+			BucketCounts:      []uint64{2, 2},
+			ExplicitBounds:    []float64{5},
+		}},
+	}}}
+
+	gauge := &metricspb.Metric{Name: "cpu.usage", Data: &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{
+		DataPoints: []*metricspb.NumberDataPoint{
+			{TimeUnixNano: 10, Value: &metricspb.NumberDataPoint_AsInt{AsInt: 3}},
+			{TimeUnixNano: 11, Flags: uint32(metricspb.DataPointFlags_DATA_POINT_FLAGS_NO_RECORDED_VALUE_MASK)},
+		},
+	}}}
+
+	summary := &metricspb.Metric{Name: "requests", Data: &metricspb.Metric_Summary{Summary: &metricspb.Summary{
+		DataPoints: []*metricspb.SummaryDataPoint{{}, {}},
+	}}}
+
+	eventTypes := map[string]bool{"requests": true, "latency": true, "cpu_usage": true}
+
+	tests := []struct {
+		name    string
+		req     *colmetricspb.ExportMetricsServiceRequest
+		data    []map[string]any
+		rejects int
+	}{
+		{
+			name: "delta sums are kept as they are",
+			req:  otlpRequest("acme", otlpSum("requests", delta, 5)),
+			data: []map[string]any{{"value": 5.0, "temporality": "delta"}},
+		},
+		{
+			name: "cumulative sums are converted to deltas, keeping their total",
+			req:  otlpRequest("acme", otlpSum("requests", cumulative, 10, 0)),
+			data: []map[string]any{{"value": 5.0, "total": 10.0, "temporality": "delta"}},
+		},
+		{
+			name: "cumulative histograms are converted to deltas, without min and max",
+			req:  otlpRequest("acme", histogram),
+			data: []map[string]any{{"count": 2.0, "sum": 4.0, "bucketCounts": []any{1.0, 1.0}, "temporality": "delta", "min": nil}},
+		},
+		{
+			name: "gauges skip points without a recorded value, and sanitise metric names",
+			req:  otlpRequest("acme", gauge),
+			data: []map[string]any{{"value": 3.0}},
+		},
+		{
+			name: "metrics without meters are skipped",
+			req:  otlpRequest("acme", otlpSum("bytes", delta, 5)),
+		},
+		{
+			name:    "unsupported metric types reject every point",
+			req:     otlpRequest("acme", summary),
+			rejects: 2,
+		},
+		{
+			name:    "resources without the subject attribute reject their points",
+			req:     otlpRequest("", otlpSum("requests", delta, 5)),
+			rejects: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events := otlpEvents(tt.req, "service.name", eventTypes, halfDeltas)
+
+			rejects := 0
+			var data []map[string]any
+			for _, e := range events {
+				if e.err != nil {
+					rejects++
+					continue
+				}
+				if e.event.Subject != "acme" {
+					t.Errorf("event subject = %q, want acme", e.event.Subject)
+				}
+				if e.event.Id == "" || e.event.Time == "" {
+					t.Errorf("event = %+v, want an id and a time", e.event)
+				}
+				data = append(data, e.event.Data)
+			}
+
+			if rejects != tt.rejects {
+				t.Errorf("rejected %d points, want %d", rejects, tt.rejects)
+			}
+			if len(data) != len(tt.data) {
+				t.Fatalf("got %d events, want %d", len(data), len(tt.data))
+			}
+			for i, want := range tt.data {
+				for name, v := range want {
+					if got := data[i][name]; !reflect.DeepEqual(got, v) {
+						t.Errorf("event %d: %s = %v, want %v", i, name, got, v)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestOtlpEventIds(t *testing.T) {
+	id := func(req *colmetricspb.ExportMetricsServiceRequest) string {
+		events := otlpEvents(req, "service.name", map[string]bool{"requests": true}, halfDeltas)
+		if len(events) != 1 || events[0].err != nil {
+			t.Fatalf("otlpEvents() = %+v, want a single event", events)
+		}
+		return events[0].event.Id
+	}
+
+	delta := metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA
+	first := id(otlpRequest("acme", otlpSum("requests", delta, 5)))
+
+	if retried := id(otlpRequest("acme", otlpSum("requests", delta, 5))); retried != first {
+		t.Errorf("retried export got id %s, want %s", retried, first)
+	}
+	if other := id(otlpRequest("globex", otlpSum("requests", delta, 5))); other == first {
+		t.Errorf("point of another resource got the same id %s", other)
+	}
+}
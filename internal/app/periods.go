@@ -0,0 +1,37 @@
+package app
+
+import (
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kloudlite/kloudmeter/internal/domain"
+	"github.com/kloudlite/kloudmeter/internal/domain/entities"
+	"github.com/kloudlite/kloudmeter/pkg/errors"
+)
+
+// parsePeriodQuery reads the meter, window and at query params. Without at, it selects the latest ended window
+func parsePeriodQuery(ctx *fiber.Ctx) (domain.PeriodQuery, error) {
+	query := domain.PeriodQuery{
+		MeterKey: ctx.Query("meter"),
+		Window:   entities.WindowSize(ctx.Query("window")),
+	}
+
+	if query.MeterKey == "" {
+		return query, errors.New("meter is required")
+	}
+
+	if err := query.Window.IsValid(); err != nil {
+		return query, err
+	}
+
+	query.At = query.Window.Start(time.Now()).Add(-time.Nanosecond)
+	if at := ctx.Query("at"); at != "" {
+		t, err := time.Parse(time.RFC3339, at)
+		if err != nil {
+			return query, errors.New("at must be in RFC3339 format")
+		}
+		query.At = t
+	}
+
+	return query, nil
+}
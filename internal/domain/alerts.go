@@ -0,0 +1,464 @@
+package domain
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kloudlite/kloudmeter/internal/domain/entities"
+	"github.com/kloudlite/kloudmeter/pkg/errors"
+	"github.com/kloudlite/kloudmeter/pkg/functions"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+const (
+	alertQueueSize       = 1000
+	alertDeliveryWorkers = 4
+	alertMaxBackoff      = 5 * time.Minute
+
+	// alertScanInterval is how often the delivery log is scanned for pending deliveries whose next attempt is due
+	alertScanInterval = 5 * time.Second
+)
+
+func (d *Impl) validateAlertRule(ctx context.Context, rule *entities.AlertRule) error {
+	if err := rule.IsValid(); err != nil {
+		return err
+	}
+
+	meter, err := d.meterRepo.Get(ctx, rule.Meter)
+	if err != nil {
+		if d.meterRepo.ErrKeyNotFound(err) {
+			return MeterNotFoundError
+		}
+		return err
+	}
+
+	return meter.ValidateQuantity(rule.Quantity)
+}
+
+func (d *Impl) CreateAlertRule(ctx context.Context, rule entities.AlertRule) (*entities.AlertRule, error) {
+	if err := d.validateAlertRule(ctx, &rule); err != nil {
+		return nil, err
+	}
+
+	get, err := d.alertRulesRepo.Get(ctx, rule.Id)
+	if err != nil && !d.alertRulesRepo.ErrKeyNotFound(err) {
+		return nil, err
+	}
+
+	if get != nil {
+		return nil, AlertRuleAlreadyExistError
+	}
+
+	if err := d.alertRulesRepo.Set(ctx, rule.Id, &rule); err != nil {
+		return nil, err
+	}
+	return rule.Redacted(), nil
+}
+
+// UpdateAlertRule replaces the rule, the webhook secret is kept when the update has none
+func (d *Impl) UpdateAlertRule(ctx context.Context, rule entities.AlertRule) (*entities.AlertRule, error) {
+	if err := d.validateAlertRule(ctx, &rule); err != nil {
+		return nil, err
+	}
+
+	current, err := d.alertRulesRepo.Get(ctx, rule.Id)
+	if err != nil {
+		if d.alertRulesRepo.ErrKeyNotFound(err) {
+			return nil, AlertRuleNotFoundError
+		}
+		return nil, err
+	}
+
+	if rule.Webhook.Secret == "" {
+		rule.Webhook.Secret = current.Webhook.Secret
+	}
+
+	if err := d.alertRulesRepo.Set(ctx, rule.Id, &rule); err != nil {
+		return nil, err
+	}
+	return rule.Redacted(), nil
+}
+
+func (d *Impl) ListAlertRules(ctx context.Context) ([]*entities.AlertRule, error) {
+	rules, err := d.alertRulesRepo.List(ctx, ">")
+	if err != nil {
+		if errors.Is(err, jetstream.ErrNoKeysFound) {
+			return []*entities.AlertRule{}, nil
+		}
+		return nil, err
+	}
+
+	for i := range rules {
+		rules[i] = rules[i].Redacted()
+	}
+	return rules, nil
+}
+
+func (d *Impl) GetAlertRule(ctx context.Context, id string) (*entities.AlertRule, error) {
+	get, err := d.alertRulesRepo.Get(ctx, id)
+	if err != nil {
+		if d.alertRulesRepo.ErrKeyNotFound(err) {
+			return nil, AlertRuleNotFoundError
+		}
+		return nil, err
+	}
+	return get.Redacted(), nil
+}
+
+func (d *Impl) DeleteAlertRule(ctx context.Context, id string) error {
+	return d.alertRulesRepo.Drop(ctx, id)
+}
+
+// ListAlertDeliveries returns the delivery log, of a single rule when ruleId is set, latest alerts first
+func (d *Impl) ListAlertDeliveries(ctx context.Context, ruleId string) ([]*entities.AlertDelivery, error) {
+	pattern := ">"
+	if ruleId != "" {
+		pattern = ruleId + ".*"
+	}
+
+	deliveries, err := d.alertDeliveriesRepo.List(ctx, pattern)
+	if err != nil {
+		if errors.Is(err, jetstream.ErrNoKeysFound) {
+			return []*entities.AlertDelivery{}, nil
+		}
+		return nil, err
+	}
+
+	sort.Slice(deliveries, func(i, j int) bool {
+		return deliveries[i].Alert.FiredAt.After(deliveries[j].Alert.FiredAt)
+	})
+	return deliveries, nil
+}
+
+// StartAlerts mirrors alert rules in memory, starts the webhook delivery workers, and the scan of the delivery log
+// that sends pending deliveries once their next attempt is due, including the ones pending when the process stopped
+func (d *Impl) StartAlerts(ctx context.Context) error {
+	go watchCache(ctx, d, "alert rules", d.alertRulesRepo, &d.alertRulesCache, nil)
+	go d.runFiredAlertsEviction(ctx)
+
+	for i := 0; i < alertDeliveryWorkers; i++ {
+		go d.runAlertDeliveries(ctx)
+	}
+
+	go d.runAlertDeliveryScan(ctx)
+	return nil
+}
+
+// runAlertDeliveryScan enqueues the pending deliveries that are due, every alertScanInterval
+func (d *Impl) runAlertDeliveryScan(ctx context.Context) {
+	ticker := time.NewTicker(alertScanInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			deliveries, err := d.alertDeliveriesRepo.List(ctx, ">")
+			if err != nil {
+				if !errors.Is(err, jetstream.ErrNoKeysFound) {
+					d.logger.Errorf(err, "failed to list pending alert deliveries")
+				}
+				continue
+			}
+
+			for _, delivery := range deliveries {
+				if delivery.Status == entities.DeliveryPending && (delivery.NextAttempt == nil || !delivery.NextAttempt.After(now)) {
+					d.enqueueAlertDelivery(ctx, delivery.Key())
+				}
+			}
+		}
+	}
+}
+
+// evaluateAlerts fires the alert rules of the meter, that the subject's reading has reached.
+// It runs after every reading write, and failures are logged, so that they never fail the reading update
+func (d *Impl) evaluateAlerts(ctx context.Context, meter *entities.Meter, key string, reading *entities.Reading) {
+	if reading.Segment != "" {
+		return
+	}
+
+	rules := d.alertRulesCache.values(func(rule *entities.AlertRule) bool {
+		return rule.Meter == meter.Key() &&
+			(rule.Subject == "" || rule.Subject == reading.Subject) &&
+			(rule.Window == "" || rule.Window == reading.Window)
+	})
+
+	for _, rule := range rules {
+		threshold, quantity := rule.Threshold, rule.Quantity
+
+		if rule.LimitPercent > 0 {
+			limit, err := d.cachedLimit(ctx, meter.Key(), reading.Subject)
+			if err != nil {
+				d.logger.Errorf(err, "failed to get limit of subject (%s) for alert rule (%s)", reading.Subject, rule.Id)
+				continue
+			}
+
+			if limit == nil || limit.Window != reading.Window {
+				continue
+			}
+
+			threshold = limit.Max * rule.LimitPercent / 100
+			if quantity == "" {
+				quantity = limit.Quantity
+			}
+		}
+
+		value, err := entities.ReadingQuantity(meter, reading, quantity)
+		if err != nil {
+			d.logger.Errorf(err, "failed to evaluate alert rule (%s) on reading (%s)", rule.Id, key)
+			continue
+		}
+
+		if value < threshold {
+			continue
+		}
+
+		if err := d.fireAlert(ctx, rule, key, reading, value, threshold); err != nil {
+			d.logger.Errorf(err, "failed to fire alert rule (%s) on reading (%s)", rule.Id, key)
+		}
+	}
+}
+
+// fireAlert fires the rule once per reading, so once per window for windowed readings. Creating its state in KV
+// only succeeds for the first consumer (or replica) to fire it, others find it exists and skip it
+func (d *Impl) fireAlert(ctx context.Context, rule *entities.AlertRule, key string, reading *entities.Reading, value float64, threshold float64) error {
+	stateKey := fmt.Sprintf("%s.%s", rule.Id, key)
+	if _, fired := d.firedAlerts.Load(stateKey); fired {
+		return nil
+	}
+
+	// fired alerts are remembered in memory until their window ends, or their state expires from KV
+	expiresAt := time.Now().Add(d.env.AlertStateTTL)
+	if reading.WindowEnd != nil && reading.WindowEnd.Before(expiresAt) {
+		expiresAt = *reading.WindowEnd
+	}
+
+	alert := &entities.Alert{
+		Id:          uuid.NewString(),
+		RuleId:      rule.Id,
+		Meter:       rule.Meter,
+		Subject:     reading.Subject,
+		Reading:     key,
+		Window:      reading.Window,
+		WindowStart: reading.WindowStart,
+		WindowEnd:   reading.WindowEnd,
+		Value:       value,
+		Threshold:   threshold,
+		FiredAt:     time.Now().UTC(),
+	}
+
+	if _, err := d.alertStatesRepo.Create(ctx, stateKey, alert); err != nil {
+		if d.alertStatesRepo.ErrRevisionMismatch(err) {
+			d.firedAlerts.Store(stateKey, expiresAt)
+			return nil
+		}
+		return err
+	}
+	d.firedAlerts.Store(stateKey, expiresAt)
+
+	delivery := &entities.AlertDelivery{
+		Id:     alert.Id,
+		RuleId: rule.Id,
+		Alert:  *alert,
+		Status: entities.DeliveryPending,
+	}
+
+	if err := d.alertDeliveriesRepo.Set(ctx, delivery.Key(), delivery); err != nil {
+		return err
+	}
+
+	d.enqueueAlertDelivery(ctx, delivery.Key())
+	return nil
+}
+
+// runFiredAlertsEviction forgets fired alerts once they expire, later evaluations of their readings fall back to
+// the alert states in KV
+func (d *Impl) runFiredAlertsEviction(ctx context.Context) {
+	ticker := time.NewTicker(cacheEvictInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			d.firedAlerts.Range(func(key, value any) bool {
+				if value.(time.Time).Before(now) {
+					d.firedAlerts.Delete(key)
+				}
+				return true
+			})
+		}
+	}
+}
+
+// enqueueAlertDelivery never blocks the caller, a delivery that does not fit the queue (or is already queued) stays
+// pending in the log, and is enqueued again by the next scan
+func (d *Impl) enqueueAlertDelivery(ctx context.Context, key string) {
+	if _, queued := d.queuedAlerts.LoadOrStore(key, true); queued {
+		return
+	}
+
+	select {
+	case d.alertQueue <- key:
+	case <-ctx.Done():
+		d.queuedAlerts.Delete(key)
+	default:
+		d.queuedAlerts.Delete(key)
+		d.logger.Warnf("alert delivery queue is full, delivery (%s) stays pending", key)
+	}
+}
+
+func (d *Impl) runAlertDeliveries(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case key := <-d.alertQueue:
+			if err := d.deliverAlert(ctx, key); err != nil {
+				d.logger.Errorf(err, "failed to deliver alert (%s)", key)
+			}
+			d.queuedAlerts.Delete(key)
+		}
+	}
+}
+
+func signPayload(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (d *Impl) postWebhook(ctx context.Context, webhook entities.Webhook, delivery *entities.AlertDelivery) (int, error) {
+	body, err := json.Marshal(delivery.Alert)
+	if err != nil {
+		return 0, err
+	}
+
+	ctx, cf := context.WithTimeout(ctx, d.env.AlertWebhookTimeout)
+	defer cf()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	for k, v := range webhook.Headers {
+		req.Header.Set(k, v)
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Kloudmeter-Delivery", delivery.Id)
+	req.Header.Set("X-Kloudmeter-Timestamp", timestamp)
+	if webhook.Secret != "" {
+		req.Header.Set("X-Kloudmeter-Signature", signPayload(webhook.Secret, timestamp, body))
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// cachedAlertRule returns the rule, or nil when it does not exist. Until the cache is synced, it falls back to KV
+func (d *Impl) cachedAlertRule(ctx context.Context, id string) (*entities.AlertRule, error) {
+	rule, ok, synced := d.alertRulesCache.get(id)
+	if ok || synced {
+		return rule, nil
+	}
+
+	rule, err := d.alertRulesRepo.Get(ctx, id)
+	if err != nil {
+		if d.alertRulesRepo.ErrKeyNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return rule, nil
+}
+
+// deliverAlert makes a single delivery attempt, and logs it. Failed attempts are retried with exponential backoff,
+// until AlertWebhookMaxAttempts are made. The attempt is claimed first, by moving NextAttempt past its timeout with
+// compare-and-swap, so that replicas scanning the same log never deliver it twice, while a delivery whose
+// process died during the attempt is retried once the claim runs out
+func (d *Impl) deliverAlert(ctx context.Context, key string) error {
+	delivery, revision, err := d.alertDeliveriesRepo.GetWithRevision(ctx, key)
+	if err != nil {
+		if d.alertDeliveriesRepo.ErrKeyNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	now := time.Now().UTC()
+	if delivery.Status != entities.DeliveryPending || (delivery.NextAttempt != nil && delivery.NextAttempt.After(now)) {
+		return nil
+	}
+
+	delivery.NextAttempt = functions.New(now.Add(d.env.AlertWebhookTimeout + alertScanInterval))
+	revision, err = d.alertDeliveriesRepo.Update(ctx, key, delivery, revision)
+	if err != nil {
+		if d.alertDeliveriesRepo.ErrRevisionMismatch(err) {
+			return nil
+		}
+		return err
+	}
+
+	rule, err := d.cachedAlertRule(ctx, delivery.RuleId)
+	if err != nil {
+		return err
+	}
+
+	if rule == nil {
+		delivery.Status = entities.DeliveryFailed
+		delivery.Attempts = append(delivery.Attempts, entities.DeliveryAttempt{At: time.Now().UTC(), Error: "alert rule no longer exists"})
+		delivery.NextAttempt = nil
+		_, err := d.alertDeliveriesRepo.Update(ctx, key, delivery, revision)
+		return err
+	}
+
+	attempt := entities.DeliveryAttempt{At: time.Now().UTC()}
+	status, err := d.postWebhook(ctx, rule.Webhook, delivery)
+	attempt.StatusCode = status
+	if err != nil {
+		attempt.Error = err.Error()
+	}
+	delivery.Attempts = append(delivery.Attempts, attempt)
+	delivery.NextAttempt = nil
+
+	switch {
+	case err == nil:
+		delivery.Status = entities.DeliveryDelivered
+	case len(delivery.Attempts) >= d.env.AlertWebhookMaxAttempts:
+		delivery.Status = entities.DeliveryFailed
+	default:
+		backoff := time.Duration(1<<(len(delivery.Attempts)-1)) * time.Second
+		if backoff > alertMaxBackoff {
+			backoff = alertMaxBackoff
+		}
+		delivery.NextAttempt = functions.New(time.Now().UTC().Add(backoff))
+	}
+
+	_, err = d.alertDeliveriesRepo.Update(ctx, key, delivery, revision)
+	return err
+}
@@ -2,25 +2,171 @@ package domain
 
 import (
 	"context"
+	"time"
 
 	"github.com/kloudlite/kloudmeter/internal/domain/entities"
 	"github.com/kloudlite/kloudmeter/pkg/errors"
 	"github.com/kloudlite/kloudmeter/pkg/kv"
 	"github.com/kloudlite/kloudmeter/pkg/messaging"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
-var MeterAlreadyExistError = errors.New("meter already exist")
+var (
+	MeterAlreadyExistError = errors.New("meter already exist")
+	MeterNotFoundError     = errors.New("meter not found")
+
+	EventTypeAlreadyExistError = errors.New("event type already exist")
+	EventTypeNotFoundError     = errors.New("event type not found")
+
+	PricePlanAlreadyExistError = errors.New("price plan already exist")
+	PricePlanNotFoundError     = errors.New("price plan not found")
+
+	LimitNotFoundError = errors.New("limit not found")
+
+	AlertRuleAlreadyExistError = errors.New("alert rule already exist")
+	AlertRuleNotFoundError     = errors.New("alert rule not found")
+
+	PeriodAlreadyClosedError = errors.New("period already closed")
+	PeriodNotFoundError      = errors.New("period not found")
+)
 
 type MeterProducer messaging.Producer
 
+// MeterVersionsRepo keeps every version of every meter, keyed as <meter-key>.<version>
+type MeterVersionsRepo kv.Repo[*entities.Meter]
+
+// EventTypeVersionsRepo keeps every version of every event type, keyed as <name>.<version>
+type EventTypeVersionsRepo kv.Repo[*entities.EventType]
+
+// SnapshotsRepo keeps the frozen readings of closed periods, keyed as their reading key
+type SnapshotsRepo kv.Repo[*entities.Reading]
+
+// WindowQuery selects readings of a meter's subject, for every window bucket between From and To (both inclusive)
+type WindowQuery struct {
+	MeterKey string
+	Subject  string
+	Segment  string
+	Window   entities.WindowSize
+	From     time.Time
+	To       time.Time
+}
+
+// RollupQuery merges a meter's dimension readings that match Dimensions, into one reading per combination of GroupBy values.
+// Subject is optional, and Window selects windowed readings (between From and To, when set) over lifetime ones
+type RollupQuery struct {
+	MeterKey   string
+	Subject    string
+	Window     entities.WindowSize
+	From       time.Time
+	To         time.Time
+	Dimensions map[string]string
+	GroupBy    []string
+}
+
+// ReadingsQuery selects a page of a meter's readings, of a Subject (or all subjects), with window buckets between From
+// and To (both inclusive, and optional). Without Segment or Dimensions it selects subject readings, otherwise the
+// readings of the matching dimension values, with a Segment of "*" selecting every one of them
+type ReadingsQuery struct {
+	MeterKey   string
+	Subject    string
+	Segment    string
+	Dimensions map[string]string
+	Window     entities.WindowSize
+	From       time.Time
+	To         time.Time
+	Sort       string
+	Cursor     string
+	Limit      int
+}
+
+// ReadingsPage is a page of readings, NextCursor selects the next page and is empty on the last one
+type ReadingsPage struct {
+	Readings   []kv.Entry[*entities.Reading] `json:"readings"`
+	NextCursor string                        `json:"nextCursor,omitempty"`
+}
+
+// SeriesQuery re-aggregates a meter's window readings into points of Step, between From and To (both inclusive).
+// Step is hour, day, month or a duration in whole hours, and Subject is optional
+type SeriesQuery struct {
+	MeterKey string
+	Subject  string
+	From     time.Time
+	To       time.Time
+	Step     string
+	Quantity string
+}
+
+// ChargeQuery prices a subject's reading with a price plan, for the Window containing At, or the lifetime reading without a Window
+type ChargeQuery struct {
+	PricePlanId string
+	Subject     string
+	Window      entities.WindowSize
+	At          time.Time
+}
+
+// PeriodQuery selects the period of a meter's Window, that contains At
+type PeriodQuery struct {
+	MeterKey string
+	Window   entities.WindowSize
+	At       time.Time
+}
+
 type Domain interface {
-	RegisterMeter(ctx context.Context, meter entities.Meter) error
+	RegisterMeter(ctx context.Context, meter entities.Meter) (*entities.Meter, error)
+	UpdateMeter(ctx context.Context, meter entities.Meter) (*entities.Meter, error)
+	ListMeterVersions(ctx context.Context, key string) ([]*entities.Meter, error)
+	GetBackfillProgress(ctx context.Context, key string) (*entities.BackfillProgress, error)
 	ListMeters(ctx context.Context) ([]kv.Entry[*entities.Meter], error)
 	DeleteMeter(ctx context.Context, key string) error
 	GetMeter(ctx context.Context, key string) (*entities.Meter, error)
 	GetReading(ctx context.Context, key string) (*entities.Reading, error)
 
 	ListReadings(ctx context.Context, pattern string) ([]kv.Entry[*entities.Reading], error)
+	ListWindowReadings(ctx context.Context, query WindowQuery) ([]kv.Entry[*entities.Reading], error)
+	RollupReadings(ctx context.Context, query RollupQuery) ([]*entities.Reading, error)
+	QueryReadings(ctx context.Context, query ReadingsQuery) (*ReadingsPage, error)
+	QuerySeries(ctx context.Context, query SeriesQuery) (*entities.Series, error)
+
+	RegisterEventType(ctx context.Context, eventType entities.EventType) (*entities.EventType, error)
+	UpdateEventType(ctx context.Context, eventType entities.EventType) (*entities.EventType, error)
+	ListEventTypes(ctx context.Context) ([]*entities.EventType, error)
+	ListEventTypeVersions(ctx context.Context, name string) ([]*entities.EventType, error)
+	GetEventType(ctx context.Context, name string) (*entities.EventType, error)
+	DeleteEventType(ctx context.Context, name string) error
+	ValidateEvent(ctx context.Context, event *entities.Event) error
+	ValidateEventData(eventType *entities.EventType, event *entities.Event) error
+
+	CreatePricePlan(ctx context.Context, plan entities.PricePlan) (*entities.PricePlan, error)
+	UpdatePricePlan(ctx context.Context, plan entities.PricePlan) (*entities.PricePlan, error)
+	ListPricePlans(ctx context.Context) ([]*entities.PricePlan, error)
+	GetPricePlan(ctx context.Context, id string) (*entities.PricePlan, error)
+	DeletePricePlan(ctx context.Context, id string) error
+	ComputeCharge(ctx context.Context, query ChargeQuery) (*entities.Charge, error)
+
+	SetLimit(ctx context.Context, limit entities.Limit) (*entities.Limit, error)
+	ListLimits(ctx context.Context, meterKey string) ([]*entities.Limit, error)
+	GetLimit(ctx context.Context, meterKey string, subject string) (*entities.Limit, error)
+	DeleteLimit(ctx context.Context, meterKey string, subject string) error
+	CheckEntitlement(ctx context.Context, meterKey string, subject string, amount float64) (*entities.Entitlement, error)
+	StartEntitlementsCache(ctx context.Context) error
+
+	CreateAlertRule(ctx context.Context, rule entities.AlertRule) (*entities.AlertRule, error)
+	UpdateAlertRule(ctx context.Context, rule entities.AlertRule) (*entities.AlertRule, error)
+	ListAlertRules(ctx context.Context) ([]*entities.AlertRule, error)
+	GetAlertRule(ctx context.Context, id string) (*entities.AlertRule, error)
+	DeleteAlertRule(ctx context.Context, id string) error
+	ListAlertDeliveries(ctx context.Context, ruleId string) ([]*entities.AlertDelivery, error)
+	StartAlerts(ctx context.Context) error
+
+	ClosePeriod(ctx context.Context, query PeriodQuery) (*entities.ClosedPeriod, error)
+	ListClosedPeriods(ctx context.Context, meterKey string) ([]*entities.ClosedPeriod, error)
+	GetSnapshot(ctx context.Context, query PeriodQuery) (*entities.UsageSnapshot, error)
+	ListAdjustments(ctx context.Context, query PeriodQuery) ([]*entities.Adjustment, error)
+	StartPeriodsCache(ctx context.Context) error
+
+	ReadingsCollector() prometheus.Collector
+
+	CumulativeDelta(ctx context.Context, series string, point *entities.CumulativePoint, monotonic bool) (map[string]float64, bool, error)
 
 	StartConsumingEvents(ctx context.Context) error
 
@@ -0,0 +1,36 @@
+package domain
+
+import (
+	"context"
+
+	"github.com/kloudlite/kloudmeter/internal/domain/entities"
+)
+
+func (d *Impl) GetBackfillProgress(ctx context.Context, key string) (*entities.BackfillProgress, error) {
+	meter, err := d.GetMeter(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := d.jc.GetConsumerInfo(ctx, d.env.MeterNatsStream, meter.Hash())
+	if err != nil {
+		return nil, err
+	}
+
+	progress := &entities.BackfillProgress{
+		MeterKey:       meter.Key(),
+		From:           meter.BackfillFrom,
+		Processed:      info.AckFloor.Consumer,
+		Pending:        info.NumPending,
+		AckPending:     info.NumAckPending,
+		StreamSequence: info.Delivered.Stream,
+		Complete:       info.NumPending == 0 && info.NumAckPending == 0,
+	}
+
+	progress.Percent = 100
+	if total := progress.Processed + progress.Pending + uint64(progress.AckPending); total > 0 {
+		progress.Percent = float64(progress.Processed) * 100 / float64(total)
+	}
+
+	return progress, nil
+}
@@ -0,0 +1,120 @@
+package domain
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/kloudlite/kloudmeter/pkg/kv"
+)
+
+// kvCache mirrors the entries of a KV bucket in memory, it is kept fresh by watchCache
+type kvCache[T any] struct {
+	sync.RWMutex
+	entries map[string]T
+	synced  bool
+
+	// strip, when set, drops what is not needed in memory from values before they are cached
+	strip func(T) T
+}
+
+func (c *kvCache[T]) get(key string) (value T, ok bool, synced bool) {
+	c.RLock()
+	defer c.RUnlock()
+	value, ok = c.entries[key]
+	return value, ok, c.synced
+}
+
+// values returns the cached values, that keep matches
+func (c *kvCache[T]) values(keep func(T) bool) []T {
+	c.RLock()
+	defer c.RUnlock()
+
+	var result []T
+	for _, v := range c.entries {
+		if keep(v) {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+func (c *kvCache[T]) update(entry kv.Entry[T], deleted bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.entries == nil {
+		c.entries = make(map[string]T)
+	}
+
+	if deleted {
+		delete(c.entries, entry.Key)
+		return
+	}
+	if c.strip != nil {
+		entry.Value = c.strip(entry.Value)
+	}
+	c.entries[entry.Key] = entry.Value
+}
+
+// retain evicts the cached entries that no longer match keep
+func (c *kvCache[T]) retain(keep func(T) bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	for key, v := range c.entries {
+		if !keep(v) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+func (c *kvCache[T]) setSynced() {
+	c.Lock()
+	defer c.Unlock()
+	c.synced = true
+}
+
+// cacheEvictInterval is how often cached entries are matched against their cache's keep filter again
+const cacheEvictInterval = time.Minute
+
+// watchCache keeps the cache in sync with the repo, re-watching after failures until ctx is done.
+// Only entries matching keep are cached, entries that stop matching it (e.g. readings of ended windows) are evicted
+// when they are written, and every cacheEvictInterval
+func watchCache[T any](ctx context.Context, d *Impl, name string, repo kv.Repo[T], cache *kvCache[T], keep func(T) bool) {
+	if keep != nil {
+		go func() {
+			ticker := time.NewTicker(cacheEvictInterval)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					cache.retain(keep)
+				}
+			}
+		}()
+	}
+
+	for {
+		err := repo.Watch(ctx, ">", func(entry kv.Entry[T], deleted bool) {
+			if !deleted && keep != nil && !keep(entry.Value) {
+				deleted = true
+			}
+			cache.update(entry, deleted)
+		}, cache.setSynced)
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		d.logger.Errorf(err, "watch of %s cache stopped, restarting", name)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
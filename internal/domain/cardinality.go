@@ -0,0 +1,72 @@
+package domain
+
+import (
+	"github.com/axiomhq/hyperloglog"
+	"github.com/kloudlite/kloudmeter/internal/domain/entities"
+)
+
+func loadHLL(encoded []byte, precision uint8) (*hyperloglog.Sketch, error) {
+	if len(encoded) == 0 {
+		return hyperloglog.NewSketch(precision, true)
+	}
+
+	sketch := &hyperloglog.Sketch{}
+	if err := sketch.UnmarshalBinary(encoded); err != nil {
+		return nil, err
+	}
+	return sketch, nil
+}
+
+// addDistinct records val into a cardinality reading.
+// In exact mode, values are kept as is, until the meter's cap is reached, post which they are moved into a HyperLogLog sketch
+func addDistinct(reading *entities.Reading, meter *entities.Meter, val string) error {
+	settings := meter.CardinalitySettings()
+
+	var sketch *hyperloglog.Sketch
+
+	if settings.Mode == entities.CardinalityExact && !reading.Approximate {
+		if reading.DistinctValues == nil {
+			reading.DistinctValues = make(map[string]bool)
+		}
+
+		if _, ok := reading.DistinctValues[val]; ok {
+			return nil
+		}
+
+		if len(reading.DistinctValues) < settings.Cap {
+			reading.DistinctValues[val] = true
+			reading.Distinct = uint64(len(reading.DistinctValues))
+			return nil
+		}
+
+		var err error
+		sketch, err = hyperloglog.NewSketch(settings.Precision, true)
+		if err != nil {
+			return err
+		}
+
+		for v := range reading.DistinctValues {
+			sketch.Insert([]byte(v))
+		}
+		reading.DistinctValues = nil
+	}
+
+	if sketch == nil {
+		var err error
+		if sketch, err = loadHLL(reading.HLL, settings.Precision); err != nil {
+			return err
+		}
+	}
+
+	sketch.Insert([]byte(val))
+
+	b, err := sketch.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	reading.HLL = b
+	reading.Distinct = sketch.Estimate()
+	reading.Approximate = true
+	return nil
+}
@@ -0,0 +1,285 @@
+package domain
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kloudlite/kloudmeter/internal/domain/entities"
+	"github.com/kloudlite/kloudmeter/pkg/errors"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+func durationStateKey(meter *entities.Meter, subject string) string {
+	return fmt.Sprintf("%s.%s", meter.Key(), subject)
+}
+
+// newAccrualSpan records quantity x seconds of the interval from until to. The span id is derived from its
+// state, resource, dimensions, quantity and bounds, so that replicas recording the same span agree on it
+func newAccrualSpan(stateKey string, interval *entities.OpenInterval, quantity float64, from, to time.Time) *entities.AccrualSpan {
+	return &entities.AccrualSpan{
+		Id: uuid.NewSHA1(uuid.NameSpaceOID, []byte(fmt.Sprintf("%s/%s/%s/%s/%s/%v", stateKey, interval.ResourceId,
+			entities.DimensionsKey(interval.Dimensions), from.Format(time.RFC3339Nano), to.Format(time.RFC3339Nano), quantity))).String(),
+		ResourceId: interval.ResourceId,
+		Quantity:   quantity,
+		From:       from,
+		To:         to,
+		Dimensions: interval.Dimensions,
+	}
+}
+
+// accrueSpan accounts the span into every window of the meter. Spans crossing window boundaries are split across
+// window buckets, and readings skip the span when it was already applied to them
+func (d *Impl) accrueSpan(ctx context.Context, meter *entities.Meter, subject string, span *entities.AccrualSpan) error {
+	segments := []string{""}
+	if len(span.Dimensions) > 0 {
+		segments = append(segments, entities.DimensionsKey(span.Dimensions))
+	}
+
+	for _, window := range readingWindows(meter) {
+		for from := span.From; from.Before(span.To); {
+			to := span.To
+			if window != "" {
+				if next := window.Next(from); next.Before(span.To) {
+					to = next
+				}
+			}
+
+			for _, segment := range segments {
+				values := upsertValues{
+					meter:     meter,
+					subject:   subject,
+					segment:   segment,
+					key:       ReadingKey(meter.Key(), subject, segment, window, from),
+					window:    window,
+					eventTime: from,
+					amount:    span.Quantity * to.Sub(from).Seconds(),
+					spanId:    span.Id,
+				}
+				if segment != "" {
+					values.dimensions = span.Dimensions
+				}
+
+				if err := d.upsertReadings(ctx, values); err != nil {
+					return err
+				}
+			}
+
+			from = to
+		}
+	}
+
+	return nil
+}
+
+// updateDurationState applies update to the subject's duration state with compare-and-swap on its KV revision,
+// retrying on the latest state when another consumer (or replica) changed it in between. update returns false
+// to leave the state as it is
+func (d *Impl) updateDurationState(ctx context.Context, meter *entities.Meter, subject string, update func(state *entities.DurationState) (bool, error)) (*entities.DurationState, error) {
+	key := durationStateKey(meter, subject)
+
+	for attempt := 1; ; attempt++ {
+		state, revision, err := d.durationStatesRepo.GetWithRevision(ctx, key)
+		if err != nil && !d.durationStatesRepo.ErrKeyNotFound(err) {
+			return nil, err
+		}
+
+		exists := err == nil
+		if !exists {
+			state = &entities.DurationState{MeterId: meter.Id, Subject: subject}
+		}
+
+		if state.Intervals == nil {
+			state.Intervals = make(map[string]*entities.OpenInterval)
+		}
+
+		changed, err := update(state)
+		if err != nil || !changed {
+			return state, err
+		}
+
+		if exists {
+			_, err = d.durationStatesRepo.Update(ctx, key, state, revision)
+		} else {
+			_, err = d.durationStatesRepo.Create(ctx, key, state)
+		}
+
+		if err == nil || !d.durationStatesRepo.ErrRevisionMismatch(err) {
+			return state, err
+		}
+
+		if attempt >= maxUpsertAttempts {
+			return nil, errors.NewEf(err, "duration state (%s) kept changing concurrently, gave up after %d attempts", key, attempt)
+		}
+
+		d.logger.Debugf("duration state (%s) was updated concurrently, retrying", key)
+	}
+}
+
+// accruePending accounts the pending spans of the state into readings, and drops them from the state once they are.
+// Spans left pending by a failure are accounted again by the next event or flush of the subject
+func (d *Impl) accruePending(ctx context.Context, meter *entities.Meter, state *entities.DurationState) error {
+	if len(state.Pending) == 0 {
+		return nil
+	}
+
+	accrued := make(map[string]bool, len(state.Pending))
+	for _, span := range state.Pending {
+		if err := d.accrueSpan(ctx, meter, state.Subject, span); err != nil {
+			return err
+		}
+		accrued[span.Id] = true
+	}
+
+	_, err := d.updateDurationState(ctx, meter, state.Subject, func(state *entities.DurationState) (bool, error) {
+		pending := make([]*entities.AccrualSpan, 0, len(state.Pending))
+		for _, span := range state.Pending {
+			if !accrued[span.Id] {
+				pending = append(pending, span)
+			}
+		}
+
+		if len(pending) == len(state.Pending) {
+			return false, nil
+		}
+
+		state.Pending = pending
+		return true, nil
+	})
+	return err
+}
+
+func (d *Impl) updateDurations(ctx context.Context, meter *entities.Meter, event *entities.Event, eventTime time.Time) error {
+	resourceId, err := dataOnPath[any](event.Data, meter.Lifecycle.ResourceIdProperty)
+	if err != nil {
+		return err
+	}
+
+	action, err := dataOnPath[string](event.Data, meter.Lifecycle.ActionProperty)
+	if err != nil {
+		return err
+	}
+
+	lifecycleAction := meter.Lifecycle.Action(*action)
+	quantity := 1.0
+
+	switch lifecycleAction {
+	case entities.LifecycleStart, entities.LifecycleResize:
+		if meter.ValueProperty != "" {
+			q, err := dataOnPath[float64](event.Data, meter.ValueProperty)
+			if err != nil {
+				return err
+			}
+			quantity = *q
+		}
+	case entities.LifecycleStop:
+	default:
+		return fmt.Errorf("unknown lifecycle action: %q", *action)
+	}
+
+	key := durationStateKey(meter, event.Subject)
+	id := fmt.Sprint(*resourceId)
+	dimensions := extractDimensions(meter, event)
+
+	// the span of the open interval is recorded along with the event, and is only then accounted into readings
+	state, err := d.updateDurationState(ctx, meter, event.Subject, func(state *entities.DurationState) (bool, error) {
+		state.AppliedEventHashes, state.AppliedEvents = state.AppliedEventHashes.With(state.AppliedEvents), nil
+		if state.AppliedEventHashes.Contains(event.Id) {
+			d.logger.Infof("event (%s) has already been applied to (%s), skipping", event.Id, key)
+			state.Duplicates = state.Duplicates + 1
+			return true, nil
+		}
+
+		interval, open := state.Intervals[id]
+		switch {
+		case open && eventTime.After(interval.Since):
+			state.Pending = append(state.Pending, newAccrualSpan(key, interval, interval.Quantity, interval.Since, eventTime))
+			interval.Since = eventTime
+
+		case open && eventTime.Before(interval.Since):
+			// the interval was already flushed past the (late) event, what was accounted since the event is credited
+			// back, and accounted again with the quantity and dimensions of the event, unless it stops the resource
+			state.Pending = append(state.Pending, newAccrualSpan(key, interval, -interval.Quantity, eventTime, interval.Since))
+			if lifecycleAction != entities.LifecycleStop {
+				resized := &entities.OpenInterval{ResourceId: id, Quantity: quantity, Dimensions: dimensions}
+				state.Pending = append(state.Pending, newAccrualSpan(key, resized, quantity, eventTime, interval.Since))
+			}
+		}
+
+		if lifecycleAction == entities.LifecycleStop {
+			delete(state.Intervals, id)
+		} else {
+			if !open {
+				interval = &entities.OpenInterval{ResourceId: id, Since: eventTime}
+				state.Intervals[id] = interval
+			}
+			interval.Quantity = quantity
+			interval.Dimensions = dimensions
+		}
+
+		state.AppliedEventHashes = state.AppliedEventHashes.Add(event.Id, d.env.DedupeHistorySize)
+		return true, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// the event is applied once its spans are recorded, failing to account them is retried by the flush
+	if err := d.accruePending(ctx, meter, state); err != nil {
+		d.logger.Errorf(err, "failed to account pending spans of (%s), retrying on the next flush", key)
+	}
+	return nil
+}
+
+// flushDurations accounts every open interval of the meter up to now, so that readings of running resources
+// stay current, and windows get closed as their boundaries pass
+func (d *Impl) flushDurations(ctx context.Context, meter *entities.Meter) error {
+	states, err := d.durationStatesRepo.Entries(ctx, meter.Key()+".*")
+	if err != nil {
+		if errors.Is(err, jetstream.ErrNoKeysFound) {
+			return nil
+		}
+		return err
+	}
+
+	now := time.Now().UTC()
+	for _, entry := range states {
+		state, err := d.updateDurationState(ctx, meter, entry.Value.Subject, func(state *entities.DurationState) (bool, error) {
+			changed := false
+			for _, interval := range state.Intervals {
+				if now.After(interval.Since) {
+					state.Pending = append(state.Pending, newAccrualSpan(entry.Key, interval, interval.Quantity, interval.Since, now))
+					interval.Since = now
+					changed = true
+				}
+			}
+			return changed, nil
+		})
+		if err != nil {
+			return err
+		}
+
+		if err := d.accruePending(ctx, meter, state); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (d *Impl) runDurationFlush(ctx context.Context, meter *entities.Meter) {
+	ticker := time.NewTicker(d.env.DurationFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := d.flushDurations(ctx, meter); err != nil {
+				d.logger.Errorf(err, "failed to flush open intervals of meter (%s)", meter.Key())
+			}
+		}
+	}
+}
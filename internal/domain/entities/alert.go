@@ -0,0 +1,124 @@
+package entities
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// Webhook is where alerts are delivered to, payloads are signed with Secret as HMAC-SHA256
+type Webhook struct {
+	URL     string            `json:"url"`
+	Secret  string            `json:"secret,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// AlertRule fires an alert when a subject's reading of the meter (with key Meter) reaches Threshold, at most once per
+// reading window. With LimitPercent, the threshold is that percentage of the subject's limit instead, e.g. 80 for 80% of quota
+type AlertRule struct {
+	Id           string     `json:"id"`
+	Description  string     `json:"description,omitempty"`
+	Meter        string     `json:"meter"`
+	Subject      string     `json:"subject,omitempty"`
+	Window       WindowSize `json:"window,omitempty"`
+	Quantity     string     `json:"quantity,omitempty"`
+	Threshold    float64    `json:"threshold,omitempty"`
+	LimitPercent float64    `json:"limitPercent,omitempty"`
+	Webhook      Webhook    `json:"webhook"`
+}
+
+var alertRuleIdRegex = pricePlanIdRegex
+
+func (a *AlertRule) IsValid() error {
+	if a.Id == "" {
+		return errors.New("id is required")
+	}
+
+	if !alertRuleIdRegex.MatchString(a.Id) {
+		return errors.New("id can only contain alphanumeric characters, dashes and underscores")
+	}
+
+	if a.Meter == "" {
+		return errors.New("meter is required")
+	}
+
+	if a.Subject != "" && !subjectRegex.MatchString(a.Subject) {
+		return errors.New("subject can only contain alphanumeric characters, dashes and underscores")
+	}
+
+	if a.Window != "" {
+		if err := a.Window.IsValid(); err != nil {
+			return err
+		}
+	}
+
+	if a.LimitPercent < 0 {
+		return errors.New("limitPercent can not be negative")
+	}
+
+	if a.LimitPercent > 0 && a.Threshold != 0 {
+		return errors.New("only one of threshold and limitPercent can be set")
+	}
+
+	if a.LimitPercent == 0 && a.Threshold == 0 {
+		return errors.New("one of threshold and limitPercent is required")
+	}
+
+	u, err := url.Parse(a.Webhook.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("webhook url must be an absolute http(s) url")
+	}
+
+	return nil
+}
+
+// Redacted returns a copy of the rule without its webhook secret, to be returned by the API
+func (a *AlertRule) Redacted() *AlertRule {
+	r := *a
+	r.Webhook.Secret = ""
+	return &r
+}
+
+// Alert is the payload delivered to an alert rule's webhook
+type Alert struct {
+	Id          string     `json:"id"`
+	RuleId      string     `json:"ruleId"`
+	Meter       string     `json:"meter"`
+	Subject     string     `json:"subject"`
+	Reading     string     `json:"reading"`
+	Window      WindowSize `json:"window,omitempty"`
+	WindowStart *time.Time `json:"windowStart,omitempty"`
+	WindowEnd   *time.Time `json:"windowEnd,omitempty"`
+	Value       float64    `json:"value"`
+	Threshold   float64    `json:"threshold"`
+	FiredAt     time.Time  `json:"firedAt"`
+}
+
+type DeliveryStatus string
+
+const (
+	DeliveryPending   DeliveryStatus = "pending"
+	DeliveryDelivered DeliveryStatus = "delivered"
+	DeliveryFailed    DeliveryStatus = "failed"
+)
+
+type DeliveryAttempt struct {
+	At         time.Time `json:"at"`
+	StatusCode int       `json:"statusCode,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// AlertDelivery logs the delivery of an alert to its rule's webhook, with every attempt made
+type AlertDelivery struct {
+	Id          string            `json:"id"`
+	RuleId      string            `json:"ruleId"`
+	Alert       Alert             `json:"alert"`
+	Status      DeliveryStatus    `json:"status"`
+	Attempts    []DeliveryAttempt `json:"attempts,omitempty"`
+	NextAttempt *time.Time        `json:"nextAttempt,omitempty"`
+}
+
+func (a *AlertDelivery) Key() string {
+	return fmt.Sprintf("%s.%s", a.RuleId, a.Id)
+}
@@ -0,0 +1,59 @@
+package entities
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+type BackfillMode string
+
+const (
+	// BackfillAll meters every event retained in the stream, it is the default
+	BackfillAll BackfillMode = "all"
+	// BackfillNone meters only events registered after the meter is created
+	BackfillNone BackfillMode = "none"
+	// BackfillSinceTime meters events stored in the stream since Time
+	BackfillSinceTime BackfillMode = "time"
+	// BackfillSinceSequence meters events since the stream sequence Sequence
+	BackfillSinceSequence BackfillMode = "sequence"
+)
+
+// BackfillFrom selects the historical events of the stream, a newly created meter consumes
+type BackfillFrom struct {
+	Mode     BackfillMode `json:"mode"`
+	Time     *time.Time   `json:"time,omitempty"`
+	Sequence uint64       `json:"sequence,omitempty"`
+}
+
+func (b *BackfillFrom) IsValid() error {
+	switch b.Mode {
+	case "", BackfillAll, BackfillNone:
+	case BackfillSinceTime:
+		if b.Time == nil {
+			return errors.New("backfillFrom.time is required for time mode")
+		}
+	case BackfillSinceSequence:
+		if b.Sequence == 0 {
+			return errors.New("backfillFrom.sequence is required for sequence mode")
+		}
+	default:
+		return fmt.Errorf("unknown backfill mode: %q", b.Mode)
+	}
+	return nil
+}
+
+// BackfillProgress reports how far a meter's consumer has come through the stream
+type BackfillProgress struct {
+	MeterKey string        `json:"meterKey"`
+	From     *BackfillFrom `json:"from,omitempty"`
+
+	// Processed is the number of events acknowledged by the consumer, and Pending the number yet to be delivered
+	Processed  uint64 `json:"processed"`
+	Pending    uint64 `json:"pending"`
+	AckPending int    `json:"ackPending"`
+	// StreamSequence is the last stream sequence delivered to the consumer
+	StreamSequence uint64  `json:"streamSequence"`
+	Percent        float64 `json:"percent"`
+	Complete       bool    `json:"complete"`
+}
@@ -0,0 +1,101 @@
+package entities
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"strings"
+)
+
+const CloudEventsSpecVersion = "1.0"
+
+// CloudEvent is the JSON format of a CloudEvents 1.0 event, https://github.com/cloudevents/spec/blob/v1.0.2/cloudevents/formats/json-format.md
+type CloudEvent struct {
+	SpecVersion     string          `json:"specversion"`
+	Id              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	Subject         string          `json:"subject,omitempty"`
+	Time            string          `json:"time,omitempty"`
+	DataContentType string          `json:"datacontenttype,omitempty"`
+	DataSchema      string          `json:"dataschema,omitempty"`
+	Data            json.RawMessage `json:"data,omitempty"`
+	DataBase64      string          `json:"data_base64,omitempty"`
+}
+
+func (c *CloudEvent) IsValid() error {
+	if c.SpecVersion != CloudEventsSpecVersion {
+		return fmt.Errorf("unsupported cloudevents specversion %q, only %q is supported", c.SpecVersion, CloudEventsSpecVersion)
+	}
+
+	if c.Id == "" {
+		return errors.New("cloudevent id is required")
+	}
+
+	if c.Source == "" {
+		return errors.New("cloudevent source is required")
+	}
+
+	if c.Type == "" {
+		return errors.New("cloudevent type is required")
+	}
+
+	if len(c.Data) > 0 && c.DataBase64 != "" {
+		return errors.New("cloudevent can not have both data and data_base64")
+	}
+
+	return nil
+}
+
+// isJSONContentType follows the spec, treating an empty content type, application/json and any +json suffix as JSON
+func isJSONContentType(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || mediaType == "text/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// ToEvent maps the cloudevent to an Event, its type becomes the eventType. Data must be a JSON object,
+// either inline, or base64 encoded in data_base64
+func (c *CloudEvent) ToEvent() (*Event, error) {
+	if err := c.IsValid(); err != nil {
+		return nil, err
+	}
+
+	data := []byte(c.Data)
+	if c.DataBase64 != "" {
+		b, err := base64.StdEncoding.DecodeString(c.DataBase64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cloudevent data_base64: %w", err)
+		}
+		data = b
+	}
+
+	event := &Event{
+		Id:        c.Id,
+		Time:      c.Time,
+		EventType: c.Type,
+		Subject:   c.Subject,
+	}
+
+	if len(data) == 0 || string(data) == "null" {
+		return event, nil
+	}
+
+	if !isJSONContentType(c.DataContentType) {
+		return nil, fmt.Errorf("cloudevent datacontenttype must be JSON, got %q", c.DataContentType)
+	}
+
+	if err := json.Unmarshal(data, &event.Data); err != nil {
+		return nil, fmt.Errorf("cloudevent data must be a JSON object: %w", err)
+	}
+
+	return event, nil
+}
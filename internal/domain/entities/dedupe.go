@@ -0,0 +1,48 @@
+package entities
+
+import "hash/fnv"
+
+// EventIds are the ids of applied events, as readings and duration states kept them before EventHashes
+type EventIds []string
+
+// EventHashes is a bounded, insertion ordered set of the 64-bit FNV-1a hashes of event ids, used to skip events that
+// were already applied. It takes 8 bytes per event, however long event ids are
+type EventHashes []uint64
+
+func hashEventId(id string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(id))
+	return h.Sum64()
+}
+
+func (e EventHashes) Contains(id string) bool {
+	hash := hashEventId(id)
+	for i := len(e) - 1; i >= 0; i-- {
+		if e[i] == hash {
+			return true
+		}
+	}
+	return false
+}
+
+// Add appends the hash of id, and evicts the oldest hashes beyond max
+func (e EventHashes) Add(id string, max int) EventHashes {
+	hashes := append(e, hashEventId(id))
+	if max > 0 && len(hashes) > max {
+		hashes = append(EventHashes(nil), hashes[len(hashes)-max:]...)
+	}
+	return hashes
+}
+
+// With returns the hashes of the ids applied before hashes were kept, followed by e
+func (e EventHashes) With(ids EventIds) EventHashes {
+	if len(ids) == 0 {
+		return e
+	}
+
+	hashes := make(EventHashes, 0, len(ids)+len(e))
+	for _, id := range ids {
+		hashes = append(hashes, hashEventId(id))
+	}
+	return append(hashes, e...)
+}
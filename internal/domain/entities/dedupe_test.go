@@ -0,0 +1,45 @@
+package entities
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestEventHashes(t *testing.T) {
+	var hashes EventHashes
+	for i := 0; i < 5; i++ {
+		hashes = hashes.Add(fmt.Sprintf("event-%d", i), 3)
+	}
+
+	tests := []struct {
+		name   string
+		hashes EventHashes
+		id     string
+		want   bool
+	}{
+		{"recent event", hashes, "event-4", true},
+		{"oldest kept event", hashes, "event-2", true},
+		{"evicted event", hashes, "event-1", false},
+		{"unknown event", hashes, "event-5", false},
+		{"empty history", nil, "event-4", false},
+		{"legacy id", EventHashes(nil).With(EventIds{"legacy-1", "legacy-2"}), "legacy-1", true},
+		{"hashes along legacy ids", hashes.With(EventIds{"legacy-1"}), "event-4", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.hashes.Contains(tt.id); got != tt.want {
+				t.Errorf("Contains(%q) = %v, want %v", tt.id, got, tt.want)
+			}
+		})
+	}
+
+	if len(hashes) != 3 {
+		t.Errorf("Add() kept %d hashes, want 3", len(hashes))
+	}
+
+	migrated := hashes.With(EventIds{"legacy-1"}).Add("event-5", 3)
+	if migrated.Contains("legacy-1") || !migrated.Contains("event-5") {
+		t.Errorf("Add() evicted %v, want legacy ids evicted first", migrated)
+	}
+}
@@ -0,0 +1,47 @@
+package entities
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var dimensionNameRegex = regexp.MustCompile(`^[a-zA-Z0-9-_]+$`)
+
+// escapeDimensionValue encodes a dimension value for reading keys, which only allow a limited set of characters.
+// Alphanumerics and dashes are kept, every other byte (underscores included) is escaped as _<hex>,
+// so that distinct values never share a key, e.g. us.east becomes us_2eeast and us/east becomes us_2feast
+func escapeDimensionValue(value string) string {
+	var sb strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c == '-' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') {
+			sb.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&sb, "_%02x", c)
+	}
+	return sb.String()
+}
+
+// DimensionToken returns the reading key token of a dimension value, e.g. region=us-east
+func DimensionToken(name string, value string) string {
+	return fmt.Sprintf("%s=%s", name, escapeDimensionValue(value))
+}
+
+// DimensionsKey returns the reading key segment for a set of dimension values, with dimensions sorted by name,
+// e.g. region=us-east.tier=premium
+func DimensionsKey(dimensions map[string]string) string {
+	names := make([]string, 0, len(dimensions))
+	for name := range dimensions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	tokens := make([]string, 0, len(names))
+	for _, name := range names {
+		tokens = append(tokens, DimensionToken(name, dimensions[name]))
+	}
+	return strings.Join(tokens, ".")
+}
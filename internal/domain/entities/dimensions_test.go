@@ -0,0 +1,55 @@
+package entities
+
+import "testing"
+
+func TestEscapeDimensionValue(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"us-east", "us-east"},
+		{"Tier1", "Tier1"},
+		{"", ""},
+		{"us.east", "us_2eeast"},
+		{"us/east", "us_2feast"},
+		{"us_east", "us_5feast"},
+		{"us_2eeast", "us_5f2eeast"},
+		{"a b*>", "a_20b_2a_3e"},
+		{"é", "_c3_a9"},
+	}
+
+	seen := map[string]string{}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got := escapeDimensionValue(tt.value)
+			if got != tt.want {
+				t.Errorf("escapeDimensionValue(%q) = %q, want %q", tt.value, got, tt.want)
+			}
+			if other, ok := seen[got]; ok {
+				t.Errorf("escapeDimensionValue(%q) = %q, same as for %q", tt.value, got, other)
+			}
+			seen[got] = tt.value
+		})
+	}
+}
+
+func TestDimensionsKey(t *testing.T) {
+	tests := []struct {
+		name       string
+		dimensions map[string]string
+		want       string
+	}{
+		{"none", nil, ""},
+		{"single", map[string]string{"region": "us-east"}, "region=us-east"},
+		{"sorted by name", map[string]string{"tier": "premium", "region": "us.east"}, "region=us_2eeast.tier=premium"},
+		{"empty value", map[string]string{"region": ""}, "region="},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DimensionsKey(tt.dimensions); got != tt.want {
+				t.Errorf("DimensionsKey() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package entities
+
+import "time"
+
+// Lifecycle describes how a duration meter reads start/stop/resize events of a resource
+type Lifecycle struct {
+	// ResourceIdProperty is the JSONPath of the resource id, intervals are tracked per subject and resource
+	ResourceIdProperty string `json:"resourceIdProperty"`
+	// ActionProperty is the JSONPath of the lifecycle action (started, stopped, resized ...) of the event
+	ActionProperty string `json:"actionProperty"`
+
+	StartActions  []string `json:"startActions,omitempty"`
+	StopActions   []string `json:"stopActions,omitempty"`
+	ResizeActions []string `json:"resizeActions,omitempty"`
+}
+
+var (
+	DefaultStartActions  = []string{"started"}
+	DefaultStopActions   = []string{"stopped"}
+	DefaultResizeActions = []string{"resized"}
+)
+
+type LifecycleAction string
+
+const (
+	LifecycleStart  LifecycleAction = "start"
+	LifecycleStop   LifecycleAction = "stop"
+	LifecycleResize LifecycleAction = "resize"
+)
+
+// Action maps the action found on an event to a lifecycle action, it returns "" for unknown actions
+func (l *Lifecycle) Action(action string) LifecycleAction {
+	has := func(actions []string, defaults []string) bool {
+		if len(actions) == 0 {
+			actions = defaults
+		}
+		for _, a := range actions {
+			if a == action {
+				return true
+			}
+		}
+		return false
+	}
+
+	switch {
+	case has(l.StartActions, DefaultStartActions):
+		return LifecycleStart
+	case has(l.StopActions, DefaultStopActions):
+		return LifecycleStop
+	case has(l.ResizeActions, DefaultResizeActions):
+		return LifecycleResize
+	}
+	return ""
+}
+
+// OpenInterval is a running resource, whose quantity has been accounted into readings up to Since
+type OpenInterval struct {
+	ResourceId string    `json:"resourceId"`
+	Quantity   float64   `json:"quantity"`
+	Since      time.Time `json:"since"`
+
+	Dimensions map[string]string `json:"dimensions,omitempty"`
+}
+
+// AccrualSpan is Quantity x seconds of a resource from From until To, recorded on the duration state before it is
+// accounted into readings. Readings dedupe spans by Id, so that a span is accounted once however often it is replayed
+type AccrualSpan struct {
+	Id         string    `json:"id"`
+	ResourceId string    `json:"resourceId"`
+	Quantity   float64   `json:"quantity"`
+	From       time.Time `json:"from"`
+	To         time.Time `json:"to"`
+
+	Dimensions map[string]string `json:"dimensions,omitempty"`
+}
+
+// DurationState holds the open intervals of a duration meter's subject, and the spans of them that are not yet
+// accounted into readings
+type DurationState struct {
+	MeterId   string                   `json:"meterId"`
+	Subject   string                   `json:"subject"`
+	Intervals map[string]*OpenInterval `json:"intervals"`
+	Pending   []*AccrualSpan           `json:"pending,omitempty"`
+
+	AppliedEventHashes EventHashes `json:"-"`
+	AppliedEvents      EventIds    `json:"-"`
+	Duplicates         int         `json:"duplicates,omitempty"`
+}
@@ -0,0 +1,323 @@
+package entities
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+type SchemaCompatibility string
+
+const (
+	// SchemaCompatibilityBackward only allows schema changes that still accept data valid under the previous version
+	SchemaCompatibilityBackward SchemaCompatibility = "backward"
+	SchemaCompatibilityNone     SchemaCompatibility = "none"
+)
+
+// EventType registers the JSON Schema that data of events of type Name must conform to
+type EventType struct {
+	Name          string              `json:"name"`
+	Description   string              `json:"description,omitempty"`
+	Version       int                 `json:"version"`
+	Schema        json.RawMessage     `json:"schema"`
+	Compatibility SchemaCompatibility `json:"compatibility,omitempty"`
+}
+
+var eventTypeNameRegex = regexp.MustCompile(`^[a-zA-Z0-9-_]+$`)
+
+func (e *EventType) VersionKey() string {
+	return fmt.Sprintf("%s.%d", e.Name, e.Version)
+}
+
+func (e *EventType) CompatibilityMode() SchemaCompatibility {
+	if e.Compatibility == "" {
+		return SchemaCompatibilityBackward
+	}
+	return e.Compatibility
+}
+
+func (e *EventType) IsValid() error {
+	if e.Name == "" {
+		return errors.New("name is required")
+	}
+
+	if !eventTypeNameRegex.MatchString(e.Name) {
+		return errors.New("name can only contain alphanumeric characters, dashes and underscores")
+	}
+
+	if len(e.Schema) == 0 {
+		return errors.New("schema is required")
+	}
+
+	var schema map[string]any
+	if err := json.Unmarshal(e.Schema, &schema); err != nil {
+		return fmt.Errorf("schema must be a JSON object: %w", err)
+	}
+
+	switch e.CompatibilityMode() {
+	case SchemaCompatibilityBackward, SchemaCompatibilityNone:
+	default:
+		return fmt.Errorf("unknown compatibility: %q", e.Compatibility)
+	}
+
+	return nil
+}
+
+// CheckBackwardCompatibility reports the changes from prev to next schema, that could reject data valid under prev.
+// Within every (nested) object schema, it rejects
+//   - a type that no longer allows every type allowed before
+//   - properties that became required, or got introduced (unless additional properties were not allowed)
+//   - additionalProperties turned false, or dropping properties that are not allowed otherwise
+//   - enums that drop values, or get introduced
+//   - lower bounds (minimum, minLength ...) that got raised or introduced, and upper bounds that got lowered or introduced
+//   - other keywords it does not understand (pattern, format, const, allOf, $ref ...) that got changed or introduced,
+//     apart from annotations
+func CheckBackwardCompatibility(prev json.RawMessage, next json.RawMessage) error {
+	var p, n map[string]any
+	if err := json.Unmarshal(prev, &p); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(next, &n); err != nil {
+		return err
+	}
+
+	var issues []string
+	checkSchemaCompatibility("$", p, n, &issues)
+	if len(issues) > 0 {
+		return fmt.Errorf("schema is not backward compatible: %s", strings.Join(issues, "; "))
+	}
+	return nil
+}
+
+// SameSchema reports whether both schemas are the same JSON, regardless of formatting and property order
+func SameSchema(a json.RawMessage, b json.RawMessage) (bool, error) {
+	var x, y any
+	if err := json.Unmarshal(a, &x); err != nil {
+		return false, err
+	}
+	if err := json.Unmarshal(b, &y); err != nil {
+		return false, err
+	}
+	return reflect.DeepEqual(x, y), nil
+}
+
+func schemaTypes(schema map[string]any) map[string]bool {
+	types := map[string]bool{}
+	switch t := schema["type"].(type) {
+	case string:
+		types[t] = true
+	case []any:
+		for _, v := range t {
+			types[fmt.Sprint(v)] = true
+		}
+	}
+	return types
+}
+
+func schemaStrings(v any) map[string]bool {
+	result := map[string]bool{}
+	if list, ok := v.([]any); ok {
+		for _, item := range list {
+			b, _ := json.Marshal(item)
+			result[string(b)] = true
+		}
+	}
+	return result
+}
+
+// schemaAnnotations are keywords that never change what a schema accepts
+var schemaAnnotations = map[string]bool{
+	"title": true, "description": true, "default": true, "examples": true, "$comment": true,
+	"deprecated": true, "readOnly": true, "writeOnly": true,
+}
+
+// schemaLowerBounds and schemaUpperBounds are keywords that are loosened by lowering, and by raising them
+var (
+	schemaLowerBounds = map[string]bool{
+		"minimum": true, "exclusiveMinimum": true, "minLength": true, "minItems": true, "minProperties": true, "minContains": true,
+	}
+	schemaUpperBounds = map[string]bool{
+		"maximum": true, "exclusiveMaximum": true, "maxLength": true, "maxItems": true, "maxProperties": true, "maxContains": true,
+	}
+)
+
+// schemaCheckedKeywords are the keywords that checkSchemaCompatibility compares on their own
+var schemaCheckedKeywords = map[string]bool{
+	"type": true, "required": true, "additionalProperties": true, "enum": true, "properties": true, "items": true,
+}
+
+func checkSchemaBound(path string, keyword string, prev map[string]any, next map[string]any, issues *[]string) {
+	nv, ok := next[keyword]
+	if !ok {
+		return
+	}
+
+	pv, ok := prev[keyword]
+	if !ok {
+		*issues = append(*issues, fmt.Sprintf("%s: %s got introduced", path, keyword))
+		return
+	}
+
+	n, nok := nv.(float64)
+	p, pok := pv.(float64)
+	switch {
+	case !nok || !pok:
+		// e.g. the boolean exclusiveMinimum of draft 4
+		if !reflect.DeepEqual(pv, nv) {
+			*issues = append(*issues, fmt.Sprintf("%s: %s changed", path, keyword))
+		}
+	case schemaLowerBounds[keyword] && n > p:
+		*issues = append(*issues, fmt.Sprintf("%s: %s got raised", path, keyword))
+	case schemaUpperBounds[keyword] && n < p:
+		*issues = append(*issues, fmt.Sprintf("%s: %s got lowered", path, keyword))
+	}
+}
+
+func checkSchemaCompatibility(path string, prev map[string]any, next map[string]any, issues *[]string) {
+	prevTypes, nextTypes := schemaTypes(prev), schemaTypes(next)
+	if len(nextTypes) > 0 {
+		if len(prevTypes) == 0 {
+			*issues = append(*issues, fmt.Sprintf("%s: type got restricted", path))
+		}
+		for t := range prevTypes {
+			// every integer is a number
+			if !nextTypes[t] && !(t == "integer" && nextTypes["number"]) {
+				*issues = append(*issues, fmt.Sprintf("%s: type %q is no longer allowed", path, t))
+			}
+		}
+	}
+
+	prevRequired, nextRequired := schemaStrings(prev["required"]), schemaStrings(next["required"])
+	var required []string
+	for r := range nextRequired {
+		if !prevRequired[r] {
+			required = append(required, r)
+		}
+	}
+	sort.Strings(required)
+	for _, r := range required {
+		*issues = append(*issues, fmt.Sprintf("%s: property %s became required", path, r))
+	}
+
+	prevAp, prevApSet := prev["additionalProperties"]
+	nextAp, nextApSet := next["additionalProperties"]
+	prevClosed := prevApSet && reflect.DeepEqual(prevAp, false)
+	nextClosed := nextApSet && reflect.DeepEqual(nextAp, false)
+
+	switch {
+	case nextClosed && !prevClosed:
+		*issues = append(*issues, fmt.Sprintf("%s: additional properties are no longer allowed", path))
+	case nextApSet && !nextClosed && !reflect.DeepEqual(prevAp, nextAp) && !reflect.DeepEqual(nextAp, true):
+		// a schema for additional properties
+		pm, pok := prevAp.(map[string]any)
+		nm, nok := nextAp.(map[string]any)
+		if pok && nok {
+			checkSchemaCompatibility(path+".*", pm, nm, issues)
+		} else if !prevClosed {
+			*issues = append(*issues, fmt.Sprintf("%s: additional properties got restricted", path))
+		}
+	}
+
+	if nextEnum, ok := next["enum"]; ok {
+		prevEnum, ok := prev["enum"]
+		if !ok {
+			*issues = append(*issues, fmt.Sprintf("%s: enum got introduced", path))
+		} else {
+			allowed := schemaStrings(nextEnum)
+			for v := range schemaStrings(prevEnum) {
+				if !allowed[v] {
+					*issues = append(*issues, fmt.Sprintf("%s: enum value %s got removed", path, v))
+				}
+			}
+		}
+	}
+
+	prevProps, _ := prev["properties"].(map[string]any)
+	nextProps, _ := next["properties"].(map[string]any)
+	names := make([]string, 0, len(prevProps)+len(nextProps))
+	for name := range prevProps {
+		names = append(names, name)
+	}
+	for name := range nextProps {
+		if _, ok := prevProps[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		p, pok := prevProps[name]
+		n, nok := nextProps[name]
+		switch {
+		case !pok:
+			// the property was free before, unless additional properties were not allowed
+			if !prevClosed && !reflect.DeepEqual(n, true) {
+				*issues = append(*issues, fmt.Sprintf("%s: property %s got introduced", path, name))
+			}
+		case !nok:
+			if nextClosed {
+				*issues = append(*issues, fmt.Sprintf("%s: property %s is no longer allowed", path, name))
+			}
+		default:
+			checkSubschemaCompatibility(path+"."+name, p, n, issues)
+		}
+	}
+
+	prevItems, pok := prev["items"]
+	nextItems, nok := next["items"]
+	switch {
+	case nok && !pok:
+		if !reflect.DeepEqual(nextItems, true) {
+			*issues = append(*issues, fmt.Sprintf("%s: items got restricted", path))
+		}
+	case nok && pok:
+		checkSubschemaCompatibility(path+"[]", prevItems, nextItems, issues)
+	}
+
+	keywords := make([]string, 0, len(prev)+len(next))
+	for k := range prev {
+		keywords = append(keywords, k)
+	}
+	for k := range next {
+		if _, ok := prev[k]; !ok {
+			keywords = append(keywords, k)
+		}
+	}
+	sort.Strings(keywords)
+
+	for _, k := range keywords {
+		switch {
+		case schemaAnnotations[k], schemaCheckedKeywords[k]:
+		case schemaLowerBounds[k], schemaUpperBounds[k]:
+			checkSchemaBound(path, k, prev, next, issues)
+		case !reflect.DeepEqual(prev[k], next[k]):
+			// dropping a keyword only loosens the schema, apart from a change of draft
+			if _, ok := next[k]; !ok && k != "$schema" {
+				continue
+			}
+			*issues = append(*issues, fmt.Sprintf("%s: %s changed", path, k))
+		}
+	}
+}
+
+// checkSubschemaCompatibility compares the schemas of a property or of items, which can also be boolean schemas
+func checkSubschemaCompatibility(path string, prev any, next any, issues *[]string) {
+	if reflect.DeepEqual(prev, next) || reflect.DeepEqual(next, true) {
+		return
+	}
+
+	p, pok := prev.(map[string]any)
+	n, nok := next.(map[string]any)
+	switch {
+	case pok && nok:
+		checkSchemaCompatibility(path, p, n, issues)
+	case reflect.DeepEqual(prev, false):
+		// nothing was accepted before
+	default:
+		*issues = append(*issues, fmt.Sprintf("%s: schema got restricted", path))
+	}
+}
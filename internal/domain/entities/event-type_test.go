@@ -0,0 +1,78 @@
+package entities
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestCheckBackwardCompatibility(t *testing.T) {
+	tests := []struct {
+		name string
+		prev string
+		next string
+		// issue is part of the expected error, an empty issue expects the schemas to be compatible
+		issue string
+	}{
+		{"same schema", `{"type":"object"}`, `{"type":"object"}`, ""},
+		{"annotations change", `{"type":"object","title":"a"}`, `{"type":"object","title":"b","description":"c"}`, ""},
+
+		{"type widened", `{"type":"string"}`, `{"type":["string","null"]}`, ""},
+		{"integer widened to number", `{"type":"integer"}`, `{"type":"number"}`, ""},
+		{"type dropped", `{"type":"string"}`, `{}`, ""},
+		{"type narrowed", `{"type":["string","null"]}`, `{"type":"string"}`, `type "null" is no longer allowed`},
+		{"type introduced", `{}`, `{"type":"object"}`, "$: type got restricted"},
+
+		{"optional property added", `{"properties":{"a":{"type":"string"}},"additionalProperties":false}`, `{"properties":{"a":{"type":"string"},"b":{"type":"number"}},"additionalProperties":false}`, ""},
+		{"free property typed", `{"properties":{}}`, `{"properties":{"b":{"type":"number"}}}`, "property b got introduced"},
+		{"free property allowed", `{"properties":{}}`, `{"properties":{"b":true}}`, ""},
+		{"property became required", `{"required":["a"]}`, `{"required":["a","b"]}`, `property "b" became required`},
+		{"property no longer required", `{"required":["a","b"]}`, `{"required":["a"]}`, ""},
+		{"property type narrowed", `{"properties":{"a":{"type":["string","number"]}}}`, `{"properties":{"a":{"type":"string"}}}`, `$.a: type "number" is no longer allowed`},
+		{"property schema made false", `{"properties":{"a":{"type":"string"}}}`, `{"properties":{"a":false}}`, "$.a: schema got restricted"},
+		{"property dropped", `{"properties":{"a":{"type":"string"}}}`, `{"properties":{}}`, ""},
+		{"property dropped from a closed schema", `{"properties":{"a":{}},"additionalProperties":false}`, `{"properties":{},"additionalProperties":false}`, "property a is no longer allowed"},
+
+		{"additional properties closed", `{"type":"object"}`, `{"type":"object","additionalProperties":false}`, "additional properties are no longer allowed"},
+		{"additional properties opened", `{"additionalProperties":false}`, `{"additionalProperties":true}`, ""},
+		{"additional properties restricted", `{}`, `{"additionalProperties":{"type":"string"}}`, "additional properties got restricted"},
+		{"additional properties schema narrowed", `{"additionalProperties":{"type":["string","number"]}}`, `{"additionalProperties":{"type":"string"}}`, `$.*: type "number" is no longer allowed`},
+
+		{"enum value added", `{"enum":["a","b"]}`, `{"enum":["a","b","c"]}`, ""},
+		{"enum value removed", `{"enum":["a","b"]}`, `{"enum":["a"]}`, `enum value "b" got removed`},
+		{"enum introduced", `{"type":"string"}`, `{"type":"string","enum":["a"]}`, "enum got introduced"},
+
+		{"items widened", `{"items":{"type":"string"}}`, `{"items":{}}`, ""},
+		{"items narrowed", `{"items":{"type":["string","number"]}}`, `{"items":{"type":"string"}}`, `$[]: type "number" is no longer allowed`},
+		{"items introduced", `{"type":"array"}`, `{"type":"array","items":{"type":"string"}}`, "items got restricted"},
+
+		{"minimum lowered", `{"minimum":5}`, `{"minimum":1}`, ""},
+		{"minimum raised", `{"minimum":1}`, `{"minimum":5}`, "minimum got raised"},
+		{"maxLength raised", `{"maxLength":5}`, `{"maxLength":10}`, ""},
+		{"maxLength lowered", `{"maxLength":10}`, `{"maxLength":5}`, "maxLength got lowered"},
+		{"maxItems introduced", `{}`, `{"maxItems":3}`, "maxItems got introduced"},
+		{"bound dropped", `{"minLength":3}`, `{}`, ""},
+		{"draft 4 exclusiveMinimum changed", `{"minimum":1,"exclusiveMinimum":false}`, `{"minimum":1,"exclusiveMinimum":true}`, "exclusiveMinimum changed"},
+
+		{"pattern introduced", `{"type":"string"}`, `{"type":"string","pattern":"^a"}`, "pattern changed"},
+		{"pattern changed", `{"pattern":"^a"}`, `{"pattern":"^b"}`, "pattern changed"},
+		{"pattern dropped", `{"pattern":"^a"}`, `{}`, ""},
+		{"format introduced", `{"type":"string"}`, `{"type":"string","format":"email"}`, "format changed"},
+		{"$schema dropped", `{"$schema":"http://json-schema.org/draft-07/schema#"}`, `{}`, "$schema changed"},
+		{"nested unknown keyword", `{"properties":{"a":{"type":"string"}}}`, `{"properties":{"a":{"type":"string","const":"x"}}}`, "$.a: const changed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckBackwardCompatibility(json.RawMessage(tt.prev), json.RawMessage(tt.next))
+			switch {
+			case tt.issue == "" && err != nil:
+				t.Errorf("CheckBackwardCompatibility() error = %v, want compatible", err)
+			case tt.issue != "" && err == nil:
+				t.Errorf("CheckBackwardCompatibility() = nil, want %q", tt.issue)
+			case tt.issue != "" && !strings.Contains(err.Error(), tt.issue):
+				t.Errorf("CheckBackwardCompatibility() error = %v, want %q", err, tt.issue)
+			}
+		})
+	}
+}
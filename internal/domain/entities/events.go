@@ -1,9 +1,11 @@
 package entities
 
 import (
+	"encoding/gob"
 	"errors"
 	"fmt"
 	"regexp"
+	"time"
 
 	"github.com/kloudlite/kloudmeter/pkg/egob"
 )
@@ -17,10 +19,32 @@ type Event struct {
 	Data      map[string]any `json:"data"`
 }
 
+func init() {
+	// event data is gob encoded, which needs the concrete types of nested objects and arrays registered
+	gob.Register(map[string]any{})
+	gob.Register([]any{})
+}
+
+// subjectRegex allows no spaces or special chars, as subjects are tokens of stream subjects and KV keys
+var subjectRegex = regexp.MustCompile(`^[a-zA-Z0-9-_]+$`)
+
 func (e *Event) Key() string {
 	return fmt.Sprintf("%s.%s.%s", e.EventType, e.Subject, e.Id)
 }
 
+// Timestamp parses Event.Time, which is expected in RFC3339 format
+func (e *Event) Timestamp() (time.Time, error) {
+	if e.Time == "" {
+		return time.Time{}, errors.New("time is required")
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, e.Time)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("time must be in RFC3339 format: %w", err)
+	}
+	return t, nil
+}
+
 func (e *Event) ParseBytes(b []byte) error {
 	return egob.Unmarshal(b, e)
 }
@@ -42,11 +66,15 @@ func (m *Event) IsValid() error {
 		return errors.New("subject is required")
 	}
 
-	// no space or special chars
-	subjectRegex := regexp.MustCompile(`^[a-zA-Z0-9-_]+$`)
 	if !subjectRegex.MatchString(m.Subject) {
 		return errors.New("subject can only contain alphanumeric characters, dashes and underscores")
 	}
 
+	if m.Time != "" {
+		if _, err := m.Timestamp(); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
@@ -0,0 +1,206 @@
+package entities
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/PaesslerAG/jsonpath"
+)
+
+type FilterOp string
+
+const (
+	FilterOpEq     FilterOp = "eq"
+	FilterOpNe     FilterOp = "ne"
+	FilterOpGt     FilterOp = "gt"
+	FilterOpGte    FilterOp = "gte"
+	FilterOpLt     FilterOp = "lt"
+	FilterOpLte    FilterOp = "lte"
+	FilterOpIn     FilterOp = "in"
+	FilterOpNotIn  FilterOp = "nin"
+	FilterOpExists FilterOp = "exists"
+)
+
+// Filter is a predicate over event data. It is either a comparison of the value at Path,
+// or a combination of other filters with And, Or or Not
+type Filter struct {
+	Path   string   `json:"path,omitempty"`
+	Op     FilterOp `json:"op,omitempty"`
+	Value  any      `json:"value,omitempty"`
+	Values []any    `json:"values,omitempty"`
+
+	And []*Filter `json:"and,omitempty"`
+	Or  []*Filter `json:"or,omitempty"`
+	Not *Filter   `json:"not,omitempty"`
+}
+
+func (f *Filter) IsValid() error {
+	kinds := 0
+	if f.Path != "" || f.Op != "" {
+		kinds++
+	}
+	if len(f.And) > 0 {
+		kinds++
+	}
+	if len(f.Or) > 0 {
+		kinds++
+	}
+	if f.Not != nil {
+		kinds++
+	}
+
+	if kinds != 1 {
+		return errors.New("filter must specify exactly one of path/op, and, or, not")
+	}
+
+	for _, sub := range append(append([]*Filter{}, f.And...), f.Or...) {
+		if sub == nil {
+			return errors.New("filter can not be null")
+		}
+		if err := sub.IsValid(); err != nil {
+			return err
+		}
+	}
+
+	if f.Not != nil {
+		return f.Not.IsValid()
+	}
+
+	if f.Path == "" && f.Op == "" {
+		return nil
+	}
+
+	if f.Path == "" {
+		return errors.New("filter path is required")
+	}
+
+	switch f.Op {
+	case FilterOpEq, FilterOpNe, FilterOpGt, FilterOpGte, FilterOpLt, FilterOpLte:
+		if f.Value == nil {
+			return fmt.Errorf("filter op %q requires a value", f.Op)
+		}
+	case FilterOpIn, FilterOpNotIn:
+		if len(f.Values) == 0 {
+			return fmt.Errorf("filter op %q requires values", f.Op)
+		}
+	case FilterOpExists:
+	default:
+		return fmt.Errorf("unknown filter op: %q", f.Op)
+	}
+
+	return nil
+}
+
+// Matches evaluates the filter against event data, comparisons on paths missing from the data never match
+func (f *Filter) Matches(data map[string]any) bool {
+	switch {
+	case len(f.And) > 0:
+		for _, sub := range f.And {
+			if !sub.Matches(data) {
+				return false
+			}
+		}
+		return true
+
+	case len(f.Or) > 0:
+		for _, sub := range f.Or {
+			if sub.Matches(data) {
+				return true
+			}
+		}
+		return false
+
+	case f.Not != nil:
+		return !f.Not.Matches(data)
+	}
+
+	v, err := jsonpath.Get(f.Path, data)
+	if err != nil {
+		return false
+	}
+
+	switch f.Op {
+	case FilterOpExists:
+		return true
+	case FilterOpEq:
+		return compareValues(v, f.Value) == 0
+	case FilterOpNe:
+		return compareValues(v, f.Value) != 0
+	case FilterOpGt:
+		c := compareValues(v, f.Value)
+		return c != incomparable && c > 0
+	case FilterOpGte:
+		c := compareValues(v, f.Value)
+		return c != incomparable && c >= 0
+	case FilterOpLt:
+		c := compareValues(v, f.Value)
+		return c != incomparable && c < 0
+	case FilterOpLte:
+		c := compareValues(v, f.Value)
+		return c != incomparable && c <= 0
+	case FilterOpIn, FilterOpNotIn:
+		found := false
+		for _, candidate := range f.Values {
+			if compareValues(v, candidate) == 0 {
+				found = true
+				break
+			}
+		}
+		return found == (f.Op == FilterOpIn)
+	}
+
+	return false
+}
+
+const incomparable = 2
+
+// compareValues compares numbers numerically, and other values by their string representation.
+// It returns incomparable when a number is compared with a non number
+func compareValues(a any, b any) int {
+	af, aNum := toFloat(a)
+	bf, bNum := toFloat(b)
+
+	switch {
+	case aNum && bNum:
+		switch {
+		case af < bf:
+			return -1
+		case af > bf:
+			return 1
+		}
+		return 0
+	case aNum != bNum:
+		return incomparable
+	}
+
+	as, bs := fmt.Sprint(a), fmt.Sprint(b)
+	switch {
+	case as < bs:
+		return -1
+	case as > bs:
+		return 1
+	}
+	return 0
+}
+
+func toFloat(v any) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint:
+		return float64(n), true
+	case uint32:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	}
+	return 0, false
+}
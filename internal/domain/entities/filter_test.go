@@ -0,0 +1,116 @@
+package entities
+
+import "testing"
+
+func TestFilterMatches(t *testing.T) {
+	data := map[string]any{
+		"tier":   "premium",
+		"cpu":    float64(4),
+		"region": "us-east",
+		"labels": map[string]any{"team": "infra"},
+	}
+
+	tests := []struct {
+		name   string
+		filter *Filter
+		want   bool
+	}{
+		{"eq string", &Filter{Path: "$.tier", Op: FilterOpEq, Value: "premium"}, true},
+		{"eq string mismatch", &Filter{Path: "$.tier", Op: FilterOpEq, Value: "free"}, false},
+		{"eq number across types", &Filter{Path: "$.cpu", Op: FilterOpEq, Value: 4}, true},
+		{"eq number with string", &Filter{Path: "$.cpu", Op: FilterOpEq, Value: "4"}, false},
+		{"ne", &Filter{Path: "$.tier", Op: FilterOpNe, Value: "free"}, true},
+		{"ne of incomparable values", &Filter{Path: "$.cpu", Op: FilterOpNe, Value: "4"}, true},
+		{"gt", &Filter{Path: "$.cpu", Op: FilterOpGt, Value: 2}, true},
+		{"gt equal", &Filter{Path: "$.cpu", Op: FilterOpGt, Value: 4}, false},
+		{"gte equal", &Filter{Path: "$.cpu", Op: FilterOpGte, Value: 4}, true},
+		{"lt", &Filter{Path: "$.cpu", Op: FilterOpLt, Value: 4.5}, true},
+		{"lte", &Filter{Path: "$.cpu", Op: FilterOpLte, Value: 3}, false},
+		{"gt of incomparable values", &Filter{Path: "$.tier", Op: FilterOpGt, Value: 1}, false},
+		{"lt of incomparable values", &Filter{Path: "$.tier", Op: FilterOpLt, Value: 1}, false},
+		{"strings compare lexically", &Filter{Path: "$.region", Op: FilterOpGt, Value: "eu-west"}, true},
+		{"in", &Filter{Path: "$.region", Op: FilterOpIn, Values: []any{"eu-west", "us-east"}}, true},
+		{"in mismatch", &Filter{Path: "$.region", Op: FilterOpIn, Values: []any{"eu-west"}}, false},
+		{"nin", &Filter{Path: "$.region", Op: FilterOpNotIn, Values: []any{"eu-west"}}, true},
+		{"nin mismatch", &Filter{Path: "$.cpu", Op: FilterOpNotIn, Values: []any{2, 4}}, false},
+		{"exists", &Filter{Path: "$.labels.team", Op: FilterOpExists}, true},
+		{"exists missing", &Filter{Path: "$.labels.owner", Op: FilterOpExists}, false},
+		{"comparison of missing path", &Filter{Path: "$.memory", Op: FilterOpNe, Value: 1}, false},
+		{"nin of missing path", &Filter{Path: "$.memory", Op: FilterOpNotIn, Values: []any{1}}, false},
+		{
+			"and",
+			&Filter{And: []*Filter{
+				{Path: "$.tier", Op: FilterOpEq, Value: "premium"},
+				{Path: "$.cpu", Op: FilterOpGte, Value: 4},
+			}},
+			true,
+		},
+		{
+			"and with a mismatch",
+			&Filter{And: []*Filter{
+				{Path: "$.tier", Op: FilterOpEq, Value: "premium"},
+				{Path: "$.cpu", Op: FilterOpGt, Value: 4},
+			}},
+			false,
+		},
+		{
+			"or",
+			&Filter{Or: []*Filter{
+				{Path: "$.tier", Op: FilterOpEq, Value: "free"},
+				{Path: "$.labels.team", Op: FilterOpEq, Value: "infra"},
+			}},
+			true,
+		},
+		{
+			"or without a match",
+			&Filter{Or: []*Filter{
+				{Path: "$.tier", Op: FilterOpEq, Value: "free"},
+				{Path: "$.memory", Op: FilterOpExists},
+			}},
+			false,
+		},
+		{"not", &Filter{Not: &Filter{Path: "$.memory", Op: FilterOpExists}}, true},
+		{"not of a match", &Filter{Not: &Filter{Path: "$.tier", Op: FilterOpEq, Value: "premium"}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.filter.IsValid(); err != nil {
+				t.Fatalf("IsValid() error = %v", err)
+			}
+			if got := tt.filter.Matches(data); got != tt.want {
+				t.Errorf("Matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterIsValid(t *testing.T) {
+	tests := []struct {
+		name    string
+		filter  *Filter
+		wantErr bool
+	}{
+		{"comparison", &Filter{Path: "$.tier", Op: FilterOpEq, Value: "premium"}, false},
+		{"exists without value", &Filter{Path: "$.tier", Op: FilterOpExists}, false},
+		{"empty", &Filter{}, true},
+		{"missing path", &Filter{Op: FilterOpEq, Value: 1}, true},
+		{"missing op", &Filter{Path: "$.tier"}, true},
+		{"unknown op", &Filter{Path: "$.tier", Op: "like", Value: "p%"}, true},
+		{"comparison without value", &Filter{Path: "$.cpu", Op: FilterOpGt}, true},
+		{"in without values", &Filter{Path: "$.tier", Op: FilterOpIn}, true},
+		{"path and combination", &Filter{Path: "$.tier", Op: FilterOpExists, Not: &Filter{Path: "$.cpu", Op: FilterOpExists}}, true},
+		{"and with or", &Filter{And: []*Filter{{Path: "$.a", Op: FilterOpExists}}, Or: []*Filter{{Path: "$.b", Op: FilterOpExists}}}, true},
+		{"null sub filter", &Filter{And: []*Filter{nil}}, true},
+		{"invalid sub filter", &Filter{Or: []*Filter{{Path: "$.a", Op: FilterOpExists}, {Path: "$.b"}}}, true},
+		{"invalid negated filter", &Filter{Not: &Filter{Op: FilterOpExists}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.filter.IsValid(); (err != nil) != tt.wantErr {
+				t.Errorf("IsValid() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
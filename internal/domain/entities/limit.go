@@ -0,0 +1,63 @@
+package entities
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Limit caps the usage of a subject on a meter (with key Meter) at Max, per Window (or over the lifetime, without a Window).
+// A limit without a Subject is the default, for every subject that has no limit of its own
+type Limit struct {
+	Meter    string     `json:"meter"`
+	Subject  string     `json:"subject,omitempty"`
+	Window   WindowSize `json:"window,omitempty"`
+	Quantity string     `json:"quantity,omitempty"`
+	Max      float64    `json:"max"`
+}
+
+func LimitKey(meterKey string, subject string) string {
+	if subject == "" {
+		return fmt.Sprintf("%s.default", meterKey)
+	}
+	return fmt.Sprintf("%s.subjects.%s", meterKey, subject)
+}
+
+func (l *Limit) Key() string {
+	return LimitKey(l.Meter, l.Subject)
+}
+
+func (l *Limit) IsValid() error {
+	if l.Meter == "" {
+		return errors.New("meter is required")
+	}
+
+	if l.Subject != "" && !subjectRegex.MatchString(l.Subject) {
+		return errors.New("subject can only contain alphanumeric characters, dashes and underscores")
+	}
+
+	if l.Window != "" {
+		if err := l.Window.IsValid(); err != nil {
+			return err
+		}
+	}
+
+	if l.Max < 0 {
+		return errors.New("max can not be negative")
+	}
+
+	return nil
+}
+
+// Entitlement is the outcome of checking a subject's usage against its limit, Limit and Remaining are nil without a limit
+type Entitlement struct {
+	Meter       string     `json:"meter"`
+	Subject     string     `json:"subject"`
+	Allowed     bool       `json:"allowed"`
+	Usage       float64    `json:"usage"`
+	Limit       *float64   `json:"limit,omitempty"`
+	Remaining   *float64   `json:"remaining,omitempty"`
+	Window      WindowSize `json:"window,omitempty"`
+	WindowStart *time.Time `json:"windowStart,omitempty"`
+	WindowEnd   *time.Time `json:"windowEnd,omitempty"`
+}
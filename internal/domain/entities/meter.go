@@ -4,6 +4,7 @@ import (
 	"crypto/md5"
 	"errors"
 	"fmt"
+	"sort"
 )
 
 type AggType string
@@ -17,27 +18,198 @@ const (
 	AggTypeRange  AggType = "range"
 	AggTypeFunc   AggType = "func"
 	AggTypeUnique AggType = "unique"
+
+	AggTypePercentile  AggType = "percentile"
+	AggTypeCardinality AggType = "cardinality"
+	AggTypeDuration    AggType = "duration"
+	AggTypeLatest      AggType = "latest"
+	AggTypeFirst       AggType = "first"
+)
+
+// FuncMerge is how values of func readings combine, when readings of several windows, subjects or dimensions are merged
+type FuncMerge string
+
+const (
+	FuncMergeSum FuncMerge = "sum"
+	FuncMergeMax FuncMerge = "max"
+	FuncMergeMin FuncMerge = "min"
 )
 
+type CardinalityMode string
+
+const (
+	// CardinalityExact keeps every distinct value, until Cap is reached, after which the reading switches to approximate counting
+	CardinalityExact CardinalityMode = "exact"
+	// CardinalityApproximate counts distinct values with a HyperLogLog sketch
+	CardinalityApproximate CardinalityMode = "approximate"
+)
+
+const (
+	DefaultCardinalityPrecision uint8 = 14
+	DefaultCardinalityCap             = 10000
+)
+
+type Cardinality struct {
+	Mode CardinalityMode `json:"mode,omitempty"`
+	// Precision of the HyperLogLog sketch (4-18), higher precision is more accurate but takes more space
+	Precision uint8 `json:"precision,omitempty"`
+	// Cap on the number of distinct values kept in exact mode
+	Cap int `json:"cap,omitempty"`
+}
+
+// DefaultMetricsMaxSeries caps the series a meter exports on /metrics, when a meter does not specify one
+const DefaultMetricsMaxSeries = 1000
+
+// Metrics configures the export of a meter's readings on /metrics
+type Metrics struct {
+	Disabled bool `json:"disabled,omitempty"`
+	// MaxSeries caps the label combinations (subject, window and dimensions) exported, beyond it readings are dropped
+	MaxSeries int `json:"maxSeries,omitempty"`
+}
+
+// DefaultRelativeAccuracy is the relative accuracy of percentile sketches, when a meter does not specify one
+const DefaultRelativeAccuracy = 0.01
+
+// IsValid reports whether readings can be computed for the aggregation type
+func (a AggType) IsValid() error {
+	switch a {
+	case AggTypeCount, AggTypeSum, AggTypeAvg, AggTypeMax, AggTypeMin, AggTypeRange, AggTypeFunc, AggTypeUnique, AggTypePercentile, AggTypeCardinality, AggTypeDuration, AggTypeLatest, AggTypeFirst:
+		return nil
+	}
+	return fmt.Errorf("unsupported aggregation type: %q", a)
+}
+
 type Meter struct {
 	Id          string `json:"id"`
 	Description string `json:"description"`
 
+	// Version is incremented on every update of the meter, previous versions are kept in the meter-versions bucket
+	Version int `json:"version"`
+
 	EventType string `json:"eventType"`
 
-	Aggregation   AggType           `json:"aggregation"`
-	ValueProperty string            `json:"valueProperty"`
-	GroupBy       map[string]string `json:"groupBy"`
+	Aggregation   AggType `json:"aggregation"`
+	ValueProperty string  `json:"valueProperty"`
+
+	// GroupBy maps a dimension name to the JSONPath of its value in event data.
+	// Besides the subject's reading, readings are kept per combination of dimension values, e.g. region=us-east.tier=premium
+	GroupBy map[string]string `json:"groupBy"`
+
+	// Func is the expression evaluated for the func aggregation, e.g. prev + $.cpu * $.durationSec / 3600
+	Func string `json:"func,omitempty"`
+	// FuncMerge declares how func readings merge for series and rollups, without it they are not merged
+	FuncMerge FuncMerge `json:"funcMerge,omitempty"`
+
+	// Quantiles are exposed on readings of percentile meters, e.g. [0.5, 0.9, 0.99]
+	Quantiles []float64 `json:"quantiles,omitempty"`
+	// RelativeAccuracy of the percentile sketch, defaults to DefaultRelativeAccuracy
+	RelativeAccuracy float64 `json:"relativeAccuracy,omitempty"`
+
+	// Cardinality configures distinct counting for cardinality meters, defaults to approximate mode
+	Cardinality *Cardinality `json:"cardinality,omitempty"`
+
+	// Lifecycle configures duration meters, which accumulate quantity (valueProperty, defaults to 1) x seconds
+	// for every resource between its start and stop events
+	Lifecycle *Lifecycle `json:"lifecycle,omitempty"`
+
+	// Filter narrows down the events of EventType that are metered, e.g. {"path": "$.tier", "op": "eq", "value": "premium"}
+	Filter *Filter `json:"filter,omitempty"`
+
+	// BackfillFrom selects the events already in the stream that are metered when the meter is created, defaults to all of them.
+	// It only applies at creation, updates to a meter keep its original value
+	BackfillFrom *BackfillFrom `json:"backfillFrom,omitempty"`
+
+	// Windows are the period sizes readings are bucketed into, based on Event.Time
+	// when empty, a single reading is accumulated for the lifetime of the meter
+	Windows []WindowSize `json:"windows,omitempty"`
+
+	// Metrics configures the export of readings on /metrics, defaults to DefaultMetricsMaxSeries series
+	Metrics *Metrics `json:"metrics,omitempty"`
 }
 
 func (m *Meter) Key() string {
 	return fmt.Sprintf("%s.%s.%s", m.EventType, m.Aggregation, m.Id)
 }
 
+// VersionKey returns the meter-versions bucket key of this version of the meter
+func (m *Meter) VersionKey() string {
+	return fmt.Sprintf("%s.%d", m.Key(), m.Version)
+}
+
 func (m *Meter) Hash() string {
 	return fmt.Sprintf("%x", md5.Sum([]byte(m.Key())))
 }
 
+func (m *Meter) SketchAccuracy() float64 {
+	if m.RelativeAccuracy == 0 {
+		return DefaultRelativeAccuracy
+	}
+	return m.RelativeAccuracy
+}
+
+// CardinalitySettings returns the meter's cardinality configuration, with defaults filled in
+func (m *Meter) CardinalitySettings() Cardinality {
+	c := Cardinality{}
+	if m.Cardinality != nil {
+		c = *m.Cardinality
+	}
+	if c.Mode == "" {
+		c.Mode = CardinalityApproximate
+	}
+	if c.Precision == 0 {
+		c.Precision = DefaultCardinalityPrecision
+	}
+	if c.Cap == 0 {
+		c.Cap = DefaultCardinalityCap
+	}
+	return c
+}
+
+// MetricsSettings returns the meter's metrics configuration, with defaults filled in
+func (m *Meter) MetricsSettings() Metrics {
+	c := Metrics{}
+	if m.Metrics != nil {
+		c = *m.Metrics
+	}
+	if c.MaxSeries == 0 {
+		c.MaxSeries = DefaultMetricsMaxSeries
+	}
+	return c
+}
+
+// HasWindow reports whether the meter keeps readings of the window, an empty window stands for the lifetime reading,
+// that only meters without windows keep
+func (m *Meter) HasWindow(window WindowSize) bool {
+	if window == "" {
+		return len(m.Windows) == 0
+	}
+
+	for _, w := range m.Windows {
+		if w == window {
+			return true
+		}
+	}
+	return false
+}
+
+// Mergeable reports whether readings of the meter can be merged into one, func readings only merge as the meter declares
+func (m *Meter) Mergeable() error {
+	if m.Aggregation == AggTypeFunc && m.FuncMerge == "" {
+		return fmt.Errorf("readings of func meter (%s) can not be merged, unless it declares funcMerge", m.Key())
+	}
+	return nil
+}
+
+// DimensionNames returns the meter's group by dimensions, sorted by name as they appear in reading keys
+func (m *Meter) DimensionNames() []string {
+	names := make([]string, 0, len(m.GroupBy))
+	for name := range m.GroupBy {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 func (m *Meter) IsValid() error {
 	if m.Id == "" {
 		return errors.New("id is required")
@@ -51,9 +223,94 @@ func (m *Meter) IsValid() error {
 		return errors.New("aggregation is required")
 	}
 
-	if m.ValueProperty == "" {
+	if err := m.Aggregation.IsValid(); err != nil {
+		return err
+	}
+
+	if m.Aggregation == AggTypeFunc {
+		if m.Func == "" {
+			return errors.New("func is required for func aggregation")
+		}
+		switch m.FuncMerge {
+		case "", FuncMergeSum, FuncMergeMax, FuncMergeMin:
+		default:
+			return fmt.Errorf("unknown funcMerge: %q", m.FuncMerge)
+		}
+	} else if m.FuncMerge != "" {
+		return errors.New("funcMerge is only valid for func aggregation")
+	} else if m.ValueProperty == "" && m.Aggregation != AggTypeDuration {
 		return errors.New("valueProperty is required")
 	}
 
+	if m.Aggregation == AggTypeDuration {
+		if m.Lifecycle == nil || m.Lifecycle.ResourceIdProperty == "" || m.Lifecycle.ActionProperty == "" {
+			return errors.New("lifecycle.resourceIdProperty and lifecycle.actionProperty are required for duration aggregation")
+		}
+	}
+
+	if m.Aggregation == AggTypePercentile {
+		if len(m.Quantiles) == 0 {
+			return errors.New("quantiles are required for percentile aggregation")
+		}
+		for _, q := range m.Quantiles {
+			if q < 0 || q > 1 {
+				return fmt.Errorf("quantile %v must be between 0 and 1", q)
+			}
+		}
+		if m.RelativeAccuracy < 0 || m.RelativeAccuracy >= 1 {
+			return errors.New("relativeAccuracy must be between 0 and 1")
+		}
+	}
+
+	if m.Aggregation == AggTypeCardinality && m.Cardinality != nil {
+		switch m.Cardinality.Mode {
+		case "", CardinalityExact, CardinalityApproximate:
+		default:
+			return fmt.Errorf("unknown cardinality mode: %q", m.Cardinality.Mode)
+		}
+		if m.Cardinality.Precision != 0 && (m.Cardinality.Precision < 4 || m.Cardinality.Precision > 18) {
+			return errors.New("cardinality precision must be between 4 and 18")
+		}
+		if m.Cardinality.Cap < 0 {
+			return errors.New("cardinality cap must not be negative")
+		}
+	}
+
+	for name, path := range m.GroupBy {
+		if !dimensionNameRegex.MatchString(name) {
+			return fmt.Errorf("groupBy dimension %q can only contain alphanumeric characters, dashes and underscores", name)
+		}
+		if path == "" {
+			return fmt.Errorf("groupBy dimension %q requires a JSONPath", name)
+		}
+	}
+
+	if m.Metrics != nil && m.Metrics.MaxSeries < 0 {
+		return errors.New("metrics maxSeries must not be negative")
+	}
+
+	if m.BackfillFrom != nil {
+		if err := m.BackfillFrom.IsValid(); err != nil {
+			return err
+		}
+	}
+
+	if m.Filter != nil {
+		if err := m.Filter.IsValid(); err != nil {
+			return err
+		}
+	}
+
+	seen := make(map[WindowSize]struct{}, len(m.Windows))
+	for _, w := range m.Windows {
+		if err := w.IsValid(); err != nil {
+			return err
+		}
+		if _, ok := seen[w]; ok {
+			return fmt.Errorf("window %q is specified more than once", w)
+		}
+		seen[w] = struct{}{}
+	}
+
 	return nil
 }
@@ -0,0 +1,11 @@
+package entities
+
+// CumulativePoint is the latest point of a cumulative OTLP series, that the next point of the series is converted
+// to deltas against. StartTime and Time are unix nanoseconds, Values are the running totals by name, and Deltas
+// what the point was converted to, nil when it was not counted
+type CumulativePoint struct {
+	StartTime uint64             `json:"startTime"`
+	Time      uint64             `json:"time"`
+	Values    map[string]float64 `json:"values"`
+	Deltas    map[string]float64 `json:"deltas,omitempty"`
+}
@@ -0,0 +1,83 @@
+package entities
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+type PeriodStatus string
+
+const (
+	// PeriodClosing is set while the readings of the period are copied into its snapshot
+	PeriodClosing PeriodStatus = "closing"
+	PeriodClosed  PeriodStatus = "closed"
+)
+
+// ClosedPeriod marks a window of a meter (with key Meter) as closed, its readings are frozen into a snapshot
+// with a Checksum of their content, and late events are recorded as adjustments instead of changing them
+type ClosedPeriod struct {
+	Meter        string       `json:"meter"`
+	MeterVersion int          `json:"meterVersion"`
+	Window       WindowSize   `json:"window"`
+	WindowStart  time.Time    `json:"windowStart"`
+	WindowEnd    time.Time    `json:"windowEnd"`
+	Status       PeriodStatus `json:"status"`
+	Readings     int          `json:"readings"`
+	Checksum     string       `json:"checksum,omitempty"`
+	ClosedAt     *time.Time   `json:"closedAt,omitempty"`
+}
+
+// PeriodKey returns the closed periods bucket key, of format <meter-key>.<window>.<window-bucket>
+func PeriodKey(meterKey string, window WindowSize, t time.Time) string {
+	return fmt.Sprintf("%s.%s.%s", meterKey, window, window.Bucket(t))
+}
+
+func (p *ClosedPeriod) Key() string {
+	return PeriodKey(p.Meter, p.Window, p.WindowStart)
+}
+
+// Contains reports whether the reading belongs to the period
+func (p *ClosedPeriod) Contains(reading *Reading) bool {
+	return reading.Window == p.Window && reading.WindowStart != nil && reading.WindowStart.Equal(p.WindowStart)
+}
+
+// SnapshotChecksum returns the hex SHA-256 of the readings' JSON, keyed by reading key. JSON objects are encoded
+// with sorted keys, so the checksum does not depend on the order readings were listed in
+func SnapshotChecksum(readings map[string]*Reading) (string, error) {
+	b, err := json.Marshal(readings)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// UsageSnapshot is the frozen readings of a closed period. Verified is false when the readings no longer match
+// the period's checksum
+type UsageSnapshot struct {
+	Period   *ClosedPeriod       `json:"period"`
+	Readings map[string]*Reading `json:"readings"`
+	Verified bool                `json:"verified"`
+}
+
+// Adjustment records an event (or, for duration meters, an accrued Amount) that arrived after its period was closed,
+// in place of updating the period's Reading
+type Adjustment struct {
+	Id         string    `json:"id"`
+	Period     string    `json:"period"`
+	Meter      string    `json:"meter"`
+	Subject    string    `json:"subject"`
+	Reading    string    `json:"reading"`
+	Event      *Event    `json:"event,omitempty"`
+	Amount     float64   `json:"amount,omitempty"`
+	EventTime  time.Time `json:"eventTime"`
+	ReceivedAt time.Time `json:"receivedAt"`
+}
+
+func (a *Adjustment) Key() string {
+	return fmt.Sprintf("%s.%s", a.Period, a.Id)
+}
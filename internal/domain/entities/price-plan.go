@@ -0,0 +1,192 @@
+package entities
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"time"
+
+	"github.com/kloudlite/kloudmeter/pkg/functions"
+)
+
+type PricingModel string
+
+const (
+	// PricingFlat charges FlatPrice per window, regardless of usage
+	PricingFlat PricingModel = "flat"
+	// PricingPerUnit charges UnitPrice for every unit
+	PricingPerUnit PricingModel = "per_unit"
+	// PricingTiered (volume) charges every unit at the price of the tier the whole quantity falls into
+	PricingTiered PricingModel = "tiered"
+	// PricingGraduated charges the units within each tier at that tier's price
+	PricingGraduated PricingModel = "graduated"
+)
+
+// PriceTier applies to quantities up to UpTo (inclusive), the last tier has no UpTo and applies to any quantity above
+type PriceTier struct {
+	UpTo      *float64 `json:"upTo,omitempty"`
+	UnitPrice float64  `json:"unitPrice"`
+	FlatPrice float64  `json:"flatPrice,omitempty"`
+}
+
+// PricePlan prices readings of the meter with key Meter. Quantity selects the reading value that is priced,
+// e.g. count, sum or a quantile like p95, and defaults to the value of the meter's aggregation
+type PricePlan struct {
+	Id          string       `json:"id"`
+	Description string       `json:"description,omitempty"`
+	Meter       string       `json:"meter"`
+	Model       PricingModel `json:"model"`
+	Currency    string       `json:"currency,omitempty"`
+	Quantity    string       `json:"quantity,omitempty"`
+	FlatPrice   float64      `json:"flatPrice,omitempty"`
+	UnitPrice   float64      `json:"unitPrice,omitempty"`
+	Tiers       []PriceTier  `json:"tiers,omitempty"`
+}
+
+var pricePlanIdRegex = regexp.MustCompile(`^[a-zA-Z0-9-_]+$`)
+
+func (p *PricePlan) IsValid() error {
+	if p.Id == "" {
+		return errors.New("id is required")
+	}
+
+	if !pricePlanIdRegex.MatchString(p.Id) {
+		return errors.New("id can only contain alphanumeric characters, dashes and underscores")
+	}
+
+	if p.Meter == "" {
+		return errors.New("meter is required")
+	}
+
+	if p.FlatPrice < 0 || p.UnitPrice < 0 {
+		return errors.New("prices can not be negative")
+	}
+
+	switch p.Model {
+	case PricingFlat, PricingPerUnit:
+		if len(p.Tiers) > 0 {
+			return fmt.Errorf("tiers are only allowed for %s and %s pricing", PricingTiered, PricingGraduated)
+		}
+
+	case PricingTiered, PricingGraduated:
+		if len(p.Tiers) == 0 {
+			return fmt.Errorf("%s pricing requires tiers", p.Model)
+		}
+
+		for i, tier := range p.Tiers {
+			if tier.UnitPrice < 0 || tier.FlatPrice < 0 {
+				return fmt.Errorf("tier %d: prices can not be negative", i)
+			}
+
+			last := i == len(p.Tiers)-1
+			if tier.UpTo == nil {
+				if !last {
+					return fmt.Errorf("tier %d: only the last tier can omit upTo", i)
+				}
+				continue
+			}
+
+			if i > 0 && p.Tiers[i-1].UpTo != nil && *tier.UpTo <= *p.Tiers[i-1].UpTo {
+				return fmt.Errorf("tier %d: upTo must be greater than the previous tier's", i)
+			}
+		}
+
+		if p.Tiers[len(p.Tiers)-1].UpTo != nil {
+			return errors.New("last tier must omit upTo, so that it applies to any quantity above")
+		}
+
+	default:
+		return fmt.Errorf("unknown pricing model: %q", p.Model)
+	}
+
+	return nil
+}
+
+// ReadingQuantity returns the value of the reading that is priced, for the plan's Quantity or the aggregation's own value
+func (p *PricePlan) ReadingQuantity(meter *Meter, reading *Reading) (float64, error) {
+	return ReadingQuantity(meter, reading, p.Quantity)
+}
+
+// ChargeLine is the part of a charge, priced by a single tier (or the plan itself, for flat and per unit pricing)
+type ChargeLine struct {
+	Tier      *int    `json:"tier,omitempty"`
+	Quantity  float64 `json:"quantity"`
+	UnitPrice float64 `json:"unitPrice"`
+	FlatPrice float64 `json:"flatPrice,omitempty"`
+	Amount    float64 `json:"amount"`
+}
+
+// Price computes the amount to charge for the quantity, with the lines it is made of
+func (p *PricePlan) Price(quantity float64) (float64, []ChargeLine) {
+	var lines []ChargeLine
+
+	switch p.Model {
+	case PricingFlat:
+		lines = append(lines, ChargeLine{Quantity: quantity, FlatPrice: p.FlatPrice, Amount: p.FlatPrice})
+
+	case PricingPerUnit:
+		lines = append(lines, ChargeLine{Quantity: quantity, UnitPrice: p.UnitPrice, Amount: quantity * p.UnitPrice})
+
+	case PricingTiered:
+		for i := range p.Tiers {
+			tier := p.Tiers[i]
+			if tier.UpTo != nil && quantity > *tier.UpTo {
+				continue
+			}
+			lines = append(lines, ChargeLine{
+				Tier:      functions.New(i),
+				Quantity:  quantity,
+				UnitPrice: tier.UnitPrice,
+				FlatPrice: tier.FlatPrice,
+				Amount:    quantity*tier.UnitPrice + tier.FlatPrice,
+			})
+			break
+		}
+
+	case PricingGraduated:
+		from := 0.0
+		for i := range p.Tiers {
+			tier := p.Tiers[i]
+			upTo := math.Inf(1)
+			if tier.UpTo != nil {
+				upTo = *tier.UpTo
+			}
+
+			// the first tier is always charged, so that its flat price applies to any usage
+			if quantity <= from && i > 0 {
+				break
+			}
+
+			units := math.Max(math.Min(quantity, upTo)-from, 0)
+			lines = append(lines, ChargeLine{
+				Tier:      functions.New(i),
+				Quantity:  units,
+				UnitPrice: tier.UnitPrice,
+				FlatPrice: tier.FlatPrice,
+				Amount:    units*tier.UnitPrice + tier.FlatPrice,
+			})
+			from = upTo
+		}
+	}
+
+	amount := 0.0
+	for _, line := range lines {
+		amount += line.Amount
+	}
+	return amount, lines
+}
+
+// Charge is the amount a subject is charged by a price plan, for a single reading of its meter
+type Charge struct {
+	PricePlan   string       `json:"pricePlan"`
+	Meter       string       `json:"meter"`
+	Subject     string       `json:"subject"`
+	Window      WindowSize   `json:"window,omitempty"`
+	WindowStart *time.Time   `json:"windowStart,omitempty"`
+	WindowEnd   *time.Time   `json:"windowEnd,omitempty"`
+	Currency    string       `json:"currency,omitempty"`
+	Quantity    float64      `json:"quantity"`
+	Amount      float64      `json:"amount"`
+	Lines       []ChargeLine `json:"lines"`
+}
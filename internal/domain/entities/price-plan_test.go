@@ -0,0 +1,102 @@
+package entities
+
+import (
+	"math"
+	"testing"
+
+	"github.com/kloudlite/kloudmeter/pkg/functions"
+)
+
+func TestPricePlanPrice(t *testing.T) {
+	tiers := []PriceTier{
+		{UpTo: functions.New(100.0), UnitPrice: 1},
+		{UpTo: functions.New(1000.0), UnitPrice: 0.5, FlatPrice: 10},
+		{UnitPrice: 0.1},
+	}
+
+	tests := []struct {
+		name     string
+		plan     PricePlan
+		quantity float64
+		amount   float64
+		lines    int
+	}{
+		{"flat", PricePlan{Model: PricingFlat, FlatPrice: 49}, 1234, 49, 1},
+		{"flat without usage", PricePlan{Model: PricingFlat, FlatPrice: 49}, 0, 49, 1},
+		{"per unit", PricePlan{Model: PricingPerUnit, UnitPrice: 0.25}, 10, 2.5, 1},
+
+		{"tiered within the first tier", PricePlan{Model: PricingTiered, Tiers: tiers}, 50, 50, 1},
+		{"tiered at an upTo is the lower tier", PricePlan{Model: PricingTiered, Tiers: tiers}, 100, 100, 1},
+		{"tiered prices the whole quantity at its tier", PricePlan{Model: PricingTiered, Tiers: tiers}, 500, 260, 1},
+		{"tiered in the last tier", PricePlan{Model: PricingTiered, Tiers: tiers}, 5000, 500, 1},
+
+		{"graduated within the first tier", PricePlan{Model: PricingGraduated, Tiers: tiers}, 50, 50, 1},
+		{"graduated at an upTo", PricePlan{Model: PricingGraduated, Tiers: tiers}, 100, 100, 1},
+		{"graduated across two tiers", PricePlan{Model: PricingGraduated, Tiers: tiers}, 500, 100 + 200 + 10, 2},
+		{"graduated across every tier", PricePlan{Model: PricingGraduated, Tiers: tiers}, 5000, 100 + 450 + 10 + 400, 3},
+		{
+			"graduated charges the first tier's flat price without usage",
+			PricePlan{Model: PricingGraduated, Tiers: []PriceTier{{UpTo: functions.New(10.0), FlatPrice: 5}, {UnitPrice: 1}}},
+			0, 5, 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			amount, lines := tt.plan.Price(tt.quantity)
+			if math.Abs(amount-tt.amount) > 1e-9 {
+				t.Errorf("Price() amount = %v, want %v", amount, tt.amount)
+			}
+			if len(lines) != tt.lines {
+				t.Errorf("Price() lines = %+v, want %d lines", lines, tt.lines)
+			}
+
+			sum := 0.0
+			for _, line := range lines {
+				sum += line.Amount
+			}
+			if math.Abs(sum-amount) > 1e-9 {
+				t.Errorf("Price() lines add up to %v, not the amount %v", sum, amount)
+			}
+		})
+	}
+}
+
+func TestPricePlanIsValid(t *testing.T) {
+	plan := func(model PricingModel, tiers ...PriceTier) *PricePlan {
+		return &PricePlan{Id: "plan", Meter: "api.count.requests", Model: model, Tiers: tiers}
+	}
+
+	tests := []struct {
+		name    string
+		plan    *PricePlan
+		wantErr bool
+	}{
+		{"flat", plan(PricingFlat), false},
+		{"graduated", plan(PricingGraduated, PriceTier{UpTo: functions.New(10.0)}, PriceTier{UnitPrice: 1}), false},
+		{"tiered with a single open tier", plan(PricingTiered, PriceTier{UnitPrice: 1}), false},
+		{"missing id", &PricePlan{Meter: "api.count.requests", Model: PricingFlat}, true},
+		{"invalid id", &PricePlan{Id: "plan 1", Meter: "api.count.requests", Model: PricingFlat}, true},
+		{"missing meter", &PricePlan{Id: "plan", Model: PricingFlat}, true},
+		{"unknown model", plan("package"), true},
+		{"negative price", &PricePlan{Id: "plan", Meter: "api.count.requests", Model: PricingPerUnit, UnitPrice: -1}, true},
+		{"tiers on flat pricing", plan(PricingFlat, PriceTier{UnitPrice: 1}), true},
+		{"tiered without tiers", plan(PricingTiered), true},
+		{"negative tier price", plan(PricingTiered, PriceTier{UnitPrice: -1}), true},
+		{"open tier before the last", plan(PricingGraduated, PriceTier{UnitPrice: 1}, PriceTier{UpTo: functions.New(10.0)}), true},
+		{"last tier with upTo", plan(PricingGraduated, PriceTier{UpTo: functions.New(10.0)}), true},
+		{
+			"upTo not increasing",
+			plan(PricingTiered, PriceTier{UpTo: functions.New(10.0)}, PriceTier{UpTo: functions.New(10.0)}, PriceTier{}),
+			true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.plan.IsValid(); (err != nil) != tt.wantErr {
+				t.Errorf("IsValid() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
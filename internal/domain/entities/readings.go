@@ -1,24 +1,166 @@
 package entities
 
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
 type Reading struct {
 	Event   string `json:"event"`
 	MeterId string `json:"meterId"`
-	Subject string `json:"subject"`
-	Segment string `json:"segment,omitempty"`
+	// MeterVersion is the version of the meter that last updated the reading
+	MeterVersion int    `json:"meterVersion,omitempty"`
+	Subject      string `json:"subject"`
+	Segment      string `json:"segment,omitempty"`
+
+	// Dimensions are the group by values of the reading, its Segment is built out of them
+	Dimensions map[string]string `json:"dimensions,omitempty"`
+
+	Window      WindowSize `json:"window,omitempty"`
+	WindowStart *time.Time `json:"windowStart,omitempty"`
+	WindowEnd   *time.Time `json:"windowEnd,omitempty"`
+
+	// Closed is set once the reading's period is closed, later updates of it are recorded as adjustments
+	Closed bool `json:"closed,omitempty"`
 
 	Type AggType `json:"type"`
 
+	// AppliedEventHashes are the latest events applied to the reading, redeliveries of them are counted as Duplicates.
+	// They are only kept in KV, cached readings and snapshots drop them. AppliedEvents are the ids applied before
+	// hashes were kept, they are hashed on the next update
+	AppliedEventHashes EventHashes `json:"-"`
+	AppliedEvents      EventIds    `json:"-"`
+	Duplicates         int         `json:"duplicates,omitempty"`
+
 	Count int     `json:"count,omitempty"`
 	Sum   float64 `json:"sum,omitempty"`
 	Avg   float64 `json:"avg,omitempty"`
 	Max   float64 `json:"max,omitempty"`
 	Min   float64 `json:"min,omitempty"`
+	Range float64 `json:"range,omitempty"`
+
+	Func  string  `json:"func,omitempty"`
+	Value float64 `json:"value,omitempty"`
 
-	Func string `json:"func,omitempty"`
+	// ObservedAt is the event time of Value, for latest and first readings
+	ObservedAt *time.Time `json:"observedAt,omitempty"`
 
 	Unique map[string]int `json:"unique,omitempty"`
+
+	// Sketch is the encoded DDSketch of percentile readings, Quantiles are derived from it on every update
+	Sketch    []byte             `json:"-"`
+	Quantiles map[string]float64 `json:"quantiles,omitempty"`
+
+	// Distinct is the distinct count of cardinality readings, it is an estimate when Approximate is set.
+	// DistinctValues holds the values seen in exact mode, and HLL the encoded HyperLogLog sketch in approximate mode
+	Distinct       uint64          `json:"distinct,omitempty"`
+	Approximate    bool            `json:"approximate,omitempty"`
+	DistinctValues map[string]bool `json:"-"`
+	HLL            []byte          `json:"-"`
+}
+
+// WithoutHistory returns a copy of the reading without its dedupe history, to be cached or snapshotted
+func (r *Reading) WithoutHistory() *Reading {
+	reading := *r
+	reading.AppliedEventHashes = nil
+	reading.AppliedEvents = nil
+	return &reading
 }
 
 // func (r *Reading) Key() string {
 // 	return fmt.Sprintf("%s.%s.%s", r.Event, r.MeterId, r.Subject)
 // }
+
+// QuantileName formats a quantile for reading responses, e.g. 0.99 as p99 and 0.999 as p99.9
+func QuantileName(q float64) string {
+	return "p" + strconv.FormatFloat(q*100, 'f', -1, 64)
+}
+
+// ValidateQuantity checks a quantity name (see ReadingQuantity) against the meter definition, quantiles
+// are only valid for percentile meters, and have to be one of the meter's quantiles
+func (m *Meter) ValidateQuantity(quantity string) error {
+	switch quantity {
+	case "":
+		if m.Aggregation == AggTypePercentile {
+			names := make([]string, 0, len(m.Quantiles))
+			for _, q := range m.Quantiles {
+				names = append(names, QuantileName(q))
+			}
+			return fmt.Errorf("quantity is required for %s readings, one of %s", m.Aggregation, strings.Join(names, ", "))
+		}
+		return nil
+	case "count", "sum", "avg", "max", "min", "range", "value", "distinct", "unique":
+		return nil
+	}
+
+	if m.Aggregation == AggTypePercentile {
+		for _, q := range m.Quantiles {
+			if QuantileName(q) == quantity {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("meter (%s) has no quantity %q", m.Key(), quantity)
+}
+
+// ReadingQuantity returns a value of the reading, by its name (count, sum, avg, max, min, range, value, distinct, unique,
+// or a quantile like p95). An empty name stands for the value of the meter's aggregation, and a nil reading for 0
+func ReadingQuantity(meter *Meter, reading *Reading, quantity string) (float64, error) {
+	if reading == nil {
+		return 0, nil
+	}
+
+	if quantity == "" {
+		switch meter.Aggregation {
+		case AggTypeCount:
+			quantity = "count"
+		case AggTypeSum, AggTypeDuration:
+			quantity = "sum"
+		case AggTypeAvg:
+			quantity = "avg"
+		case AggTypeMax:
+			quantity = "max"
+		case AggTypeMin:
+			quantity = "min"
+		case AggTypeRange:
+			quantity = "range"
+		case AggTypeFunc, AggTypeLatest, AggTypeFirst:
+			quantity = "value"
+		case AggTypeCardinality:
+			quantity = "distinct"
+		case AggTypeUnique:
+			quantity = "unique"
+		default:
+			return 0, fmt.Errorf("quantity is required for %s readings", meter.Aggregation)
+		}
+	}
+
+	switch quantity {
+	case "count":
+		return float64(reading.Count), nil
+	case "sum":
+		return reading.Sum, nil
+	case "avg":
+		return reading.Avg, nil
+	case "max":
+		return reading.Max, nil
+	case "min":
+		return reading.Min, nil
+	case "range":
+		return reading.Range, nil
+	case "value":
+		return reading.Value, nil
+	case "distinct":
+		return float64(reading.Distinct), nil
+	case "unique":
+		return float64(len(reading.Unique)), nil
+	}
+
+	if v, ok := reading.Quantiles[quantity]; ok {
+		return v, nil
+	}
+
+	return 0, fmt.Errorf("reading has no quantity %q", quantity)
+}
@@ -0,0 +1,19 @@
+package entities
+
+import "time"
+
+// SeriesPoint is the value of a meter's readings in the step starting at Start, Value is nil for steps without readings
+type SeriesPoint struct {
+	Start time.Time `json:"start"`
+	Value *float64  `json:"value"`
+}
+
+// Series is a meter's usage over time, re-aggregated from the readings of its Source window into Step sized points
+type Series struct {
+	Meter    string        `json:"meter"`
+	Subject  string        `json:"subject,omitempty"`
+	Step     string        `json:"step"`
+	Source   WindowSize    `json:"source"`
+	Quantity string        `json:"quantity,omitempty"`
+	Points   []SeriesPoint `json:"points"`
+}
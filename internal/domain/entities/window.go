@@ -0,0 +1,74 @@
+package entities
+
+import (
+	"fmt"
+	"time"
+)
+
+type WindowSize string
+
+const (
+	WindowHour  WindowSize = "hour"
+	WindowDay   WindowSize = "day"
+	WindowMonth WindowSize = "month"
+)
+
+func (w WindowSize) IsValid() error {
+	switch w {
+	case WindowHour, WindowDay, WindowMonth:
+		return nil
+	}
+	return fmt.Errorf("unknown window size: %q", w)
+}
+
+func (w WindowSize) layout() string {
+	switch w {
+	case WindowHour:
+		return "2006-01-02T15"
+	case WindowDay:
+		return "2006-01-02"
+	case WindowMonth:
+		return "2006-01"
+	}
+	return ""
+}
+
+// Start returns the beginning of the window (in UTC) that t falls into
+func (w WindowSize) Start(t time.Time) time.Time {
+	t = t.UTC()
+	switch w {
+	case WindowHour:
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, time.UTC)
+	case WindowDay:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	case WindowMonth:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+	return t
+}
+
+// Next returns the beginning of the window following the one t falls into
+func (w WindowSize) Next(t time.Time) time.Time {
+	start := w.Start(t)
+	switch w {
+	case WindowHour:
+		return start.Add(time.Hour)
+	case WindowDay:
+		return start.AddDate(0, 0, 1)
+	case WindowMonth:
+		return start.AddDate(0, 1, 0)
+	}
+	return start
+}
+
+// Bucket returns the key suffix for the window t falls into, e.g. 2026-10-18T00 for an hourly window
+func (w WindowSize) Bucket(t time.Time) string {
+	return w.Start(t).Format(w.layout())
+}
+
+func (w WindowSize) ParseBucket(bucket string) (time.Time, error) {
+	if err := w.IsValid(); err != nil {
+		return time.Time{}, err
+	}
+	return time.ParseInLocation(w.layout(), bucket, time.UTC)
+}
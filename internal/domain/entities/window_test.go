@@ -0,0 +1,123 @@
+package entities
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWindowSize(t *testing.T) {
+	ist := time.FixedZone("IST", 5*3600+1800)
+
+	tests := []struct {
+		name   string
+		window WindowSize
+		t      time.Time
+		start  time.Time
+		next   time.Time
+		bucket string
+	}{
+		{
+			name:   "hour",
+			window: WindowHour,
+			t:      time.Date(2026, 10, 18, 14, 48, 19, 5, time.UTC),
+			start:  time.Date(2026, 10, 18, 14, 0, 0, 0, time.UTC),
+			next:   time.Date(2026, 10, 18, 15, 0, 0, 0, time.UTC),
+			bucket: "2026-10-18T14",
+		},
+		{
+			name:   "hour boundary belongs to the window it starts",
+			window: WindowHour,
+			t:      time.Date(2026, 10, 18, 15, 0, 0, 0, time.UTC),
+			start:  time.Date(2026, 10, 18, 15, 0, 0, 0, time.UTC),
+			next:   time.Date(2026, 10, 18, 16, 0, 0, 0, time.UTC),
+			bucket: "2026-10-18T15",
+		},
+		{
+			name:   "day across midnight",
+			window: WindowDay,
+			t:      time.Date(2026, 12, 31, 23, 59, 59, 0, time.UTC),
+			start:  time.Date(2026, 12, 31, 0, 0, 0, 0, time.UTC),
+			next:   time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC),
+			bucket: "2026-12-31",
+		},
+		{
+			name:   "day of a time in another zone is the UTC day",
+			window: WindowDay,
+			t:      time.Date(2026, 10, 19, 2, 0, 0, 0, ist),
+			start:  time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC),
+			next:   time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC),
+			bucket: "2026-10-18",
+		},
+		{
+			name:   "month",
+			window: WindowMonth,
+			t:      time.Date(2026, 10, 18, 14, 0, 0, 0, time.UTC),
+			start:  time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC),
+			next:   time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC),
+			bucket: "2026-10",
+		},
+		{
+			name:   "month of leap february",
+			window: WindowMonth,
+			t:      time.Date(2028, 2, 29, 12, 0, 0, 0, time.UTC),
+			start:  time.Date(2028, 2, 1, 0, 0, 0, 0, time.UTC),
+			next:   time.Date(2028, 3, 1, 0, 0, 0, 0, time.UTC),
+			bucket: "2028-02",
+		},
+		{
+			name:   "december rolls over to the next year",
+			window: WindowMonth,
+			t:      time.Date(2026, 12, 31, 23, 0, 0, 0, time.UTC),
+			start:  time.Date(2026, 12, 1, 0, 0, 0, 0, time.UTC),
+			next:   time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC),
+			bucket: "2026-12",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.window.Start(tt.t); !got.Equal(tt.start) {
+				t.Errorf("Start() = %v, want %v", got, tt.start)
+			}
+			if got := tt.window.Next(tt.t); !got.Equal(tt.next) {
+				t.Errorf("Next() = %v, want %v", got, tt.next)
+			}
+			if got := tt.window.Bucket(tt.t); got != tt.bucket {
+				t.Errorf("Bucket() = %q, want %q", got, tt.bucket)
+			}
+
+			start, err := tt.window.ParseBucket(tt.bucket)
+			if err != nil {
+				t.Fatalf("ParseBucket() error = %v", err)
+			}
+			if !start.Equal(tt.start) {
+				t.Errorf("ParseBucket() = %v, want %v", start, tt.start)
+			}
+		})
+	}
+}
+
+func TestWindowSizeIsValid(t *testing.T) {
+	tests := []struct {
+		window  WindowSize
+		wantErr bool
+	}{
+		{WindowHour, false},
+		{WindowDay, false},
+		{WindowMonth, false},
+		{"", true},
+		{"week", true},
+		{"Day", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.window), func(t *testing.T) {
+			if err := tt.window.IsValid(); (err != nil) != tt.wantErr {
+				t.Errorf("IsValid() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if _, err := tt.window.ParseBucket("2026-10"); tt.wantErr && err == nil {
+				t.Errorf("ParseBucket() of an invalid window did not fail")
+			}
+		})
+	}
+}
@@ -0,0 +1,216 @@
+package domain
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/kloudlite/kloudmeter/internal/domain/entities"
+	"github.com/kloudlite/kloudmeter/pkg/errors"
+	"github.com/kloudlite/kloudmeter/pkg/functions"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// StartEntitlementsCache mirrors limits, meters and current readings in memory, so that entitlement checks
+// (and /metrics scrapes) do not hit KV
+func (d *Impl) StartEntitlementsCache(ctx context.Context) error {
+	go watchCache(ctx, d, "limits", d.limitsRepo, &d.limitsCache, nil)
+	go watchCache(ctx, d, "meters", d.meterRepo, &d.metersCache, nil)
+
+	// readings of past windows are never checked against limits, nor exported as metrics
+	// and their dedupe history is only needed when they are updated
+	d.readingsCache.strip = (*entities.Reading).WithoutHistory
+	go watchCache(ctx, d, "readings", d.readingsRepo, &d.readingsCache, d.isCachedReading)
+
+	return nil
+}
+
+func isCurrentReading(r *entities.Reading) bool {
+	return r.WindowEnd == nil || r.WindowEnd.After(time.Now())
+}
+
+// isCachedReading keeps current readings of subjects, which limits are checked against, and current dimension
+// readings only for meters that export metrics. Until meters are synced, dimension readings are kept,
+// and evicted later on when their meter turns out not to export metrics
+func (d *Impl) isCachedReading(r *entities.Reading) bool {
+	if !isCurrentReading(r) {
+		return false
+	}
+
+	if r.Segment == "" {
+		return true
+	}
+
+	meter, ok, synced := d.metersCache.get(fmt.Sprintf("%s.%s.%s", r.Event, r.Type, r.MeterId))
+	if !ok {
+		return !synced
+	}
+	return !meter.MetricsSettings().Disabled
+}
+
+func (d *Impl) SetLimit(ctx context.Context, limit entities.Limit) (*entities.Limit, error) {
+	if err := limit.IsValid(); err != nil {
+		return nil, err
+	}
+
+	meter, err := d.meterRepo.Get(ctx, limit.Meter)
+	if err != nil {
+		if d.meterRepo.ErrKeyNotFound(err) {
+			return nil, MeterNotFoundError
+		}
+		return nil, err
+	}
+
+	if !meter.HasWindow(limit.Window) {
+		return nil, errors.Newf("meter (%s) keeps no readings of window %q", meter.Key(), limit.Window)
+	}
+
+	if err := meter.ValidateQuantity(limit.Quantity); err != nil {
+		return nil, err
+	}
+
+	if err := d.limitsRepo.Set(ctx, limit.Key(), &limit); err != nil {
+		return nil, err
+	}
+	return &limit, nil
+}
+
+func (d *Impl) ListLimits(ctx context.Context, meterKey string) ([]*entities.Limit, error) {
+	pattern := ">"
+	if meterKey != "" {
+		pattern = meterKey + ".>"
+	}
+
+	limits, err := d.limitsRepo.List(ctx, pattern)
+	if err != nil {
+		if errors.Is(err, jetstream.ErrNoKeysFound) {
+			return []*entities.Limit{}, nil
+		}
+		return nil, err
+	}
+	return limits, nil
+}
+
+func (d *Impl) GetLimit(ctx context.Context, meterKey string, subject string) (*entities.Limit, error) {
+	get, err := d.limitsRepo.Get(ctx, entities.LimitKey(meterKey, subject))
+	if err != nil {
+		if d.limitsRepo.ErrKeyNotFound(err) {
+			return nil, LimitNotFoundError
+		}
+		return nil, err
+	}
+	return get, nil
+}
+
+func (d *Impl) DeleteLimit(ctx context.Context, meterKey string, subject string) error {
+	return d.limitsRepo.Drop(ctx, entities.LimitKey(meterKey, subject))
+}
+
+// cachedLimit returns the subject's own limit, or the meter's default one. Until the cache is synced, it falls back to KV
+func (d *Impl) cachedLimit(ctx context.Context, meterKey string, subject string) (*entities.Limit, error) {
+	for _, key := range []string{entities.LimitKey(meterKey, subject), entities.LimitKey(meterKey, "")} {
+		limit, ok, synced := d.limitsCache.get(key)
+		if ok {
+			return limit, nil
+		}
+		if synced {
+			continue
+		}
+
+		limit, err := d.limitsRepo.Get(ctx, key)
+		if err != nil {
+			if d.limitsRepo.ErrKeyNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		return limit, nil
+	}
+	return nil, nil
+}
+
+func (d *Impl) cachedMeter(ctx context.Context, key string) (*entities.Meter, error) {
+	meter, ok, synced := d.metersCache.get(key)
+	if ok {
+		return meter, nil
+	}
+	if synced {
+		return nil, MeterNotFoundError
+	}
+
+	meter, err := d.meterRepo.Get(ctx, key)
+	if err != nil {
+		if d.meterRepo.ErrKeyNotFound(err) {
+			return nil, MeterNotFoundError
+		}
+		return nil, err
+	}
+	return meter, nil
+}
+
+// cachedReading returns the reading at key, or nil when it does not exist. Until the cache is synced, it falls back to KV
+func (d *Impl) cachedReading(ctx context.Context, key string) (*entities.Reading, error) {
+	reading, ok, synced := d.readingsCache.get(key)
+	if ok || synced {
+		return reading, nil
+	}
+
+	reading, err := d.readingsRepo.Get(ctx, key)
+	if err != nil {
+		if d.readingsRepo.ErrKeyNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return reading, nil
+}
+
+// CheckEntitlement compares the subject's current usage against its limit, it is allowed while usage plus amount
+// stays within the limit (or, for an amount of 0, while usage is below it). Subjects without a limit are always allowed
+func (d *Impl) CheckEntitlement(ctx context.Context, meterKey string, subject string, amount float64) (*entities.Entitlement, error) {
+	entitlement := &entities.Entitlement{Meter: meterKey, Subject: subject, Allowed: true}
+
+	limit, err := d.cachedLimit(ctx, meterKey, subject)
+	if err != nil {
+		return nil, err
+	}
+
+	if limit == nil {
+		return entitlement, nil
+	}
+
+	meter, err := d.cachedMeter(ctx, meterKey)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	reading, err := d.cachedReading(ctx, ReadingKey(meterKey, subject, "", limit.Window, now))
+	if err != nil {
+		return nil, err
+	}
+
+	usage, err := entities.ReadingQuantity(meter, reading, limit.Quantity)
+	if err != nil {
+		return nil, err
+	}
+
+	remaining := math.Max(limit.Max-usage, 0)
+
+	entitlement.Usage = usage
+	entitlement.Limit = functions.New(limit.Max)
+	entitlement.Remaining = functions.New(remaining)
+	entitlement.Allowed = usage+amount <= limit.Max
+	if amount == 0 {
+		entitlement.Allowed = usage < limit.Max
+	}
+
+	if limit.Window != "" {
+		entitlement.Window = limit.Window
+		entitlement.WindowStart = functions.New(limit.Window.Start(now))
+		entitlement.WindowEnd = functions.New(limit.Window.Next(now))
+	}
+
+	return entitlement, nil
+}
@@ -0,0 +1,246 @@
+package domain
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/kloudlite/kloudmeter/internal/domain/entities"
+	"github.com/kloudlite/kloudmeter/pkg/errors"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+func compileSchema(eventType *entities.EventType) (*jsonschema.Schema, error) {
+	url := fmt.Sprintf("kloudmeter://event-types/%s/%d", eventType.Name, eventType.Version)
+
+	compiler := jsonschema.NewCompiler()
+	// schemas come from the API, so external refs are never loaded, which would read local files or make requests
+	compiler.LoadURL = func(s string) (io.ReadCloser, error) {
+		return nil, fmt.Errorf("external schema %q can not be referenced, only refs within the schema are resolved", s)
+	}
+	if err := compiler.AddResource(url, bytes.NewReader(eventType.Schema)); err != nil {
+		return nil, fmt.Errorf("invalid schema: %w", err)
+	}
+
+	schema, err := compiler.Compile(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schema: %w", err)
+	}
+	return schema, nil
+}
+
+// eventSchemas caches compiled schemas, keyed by the event type's version key
+type eventSchemas struct {
+	sync.Mutex
+	schemas map[string]*jsonschema.Schema
+}
+
+func (s *eventSchemas) get(eventType *entities.EventType) (*jsonschema.Schema, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if schema, ok := s.schemas[eventType.VersionKey()]; ok {
+		return schema, nil
+	}
+
+	schema, err := compileSchema(eventType)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.schemas == nil {
+		s.schemas = make(map[string]*jsonschema.Schema)
+	}
+	s.schemas[eventType.VersionKey()] = schema
+	return schema, nil
+}
+
+func (d *Impl) validateEventType(eventType *entities.EventType) error {
+	if err := eventType.IsValid(); err != nil {
+		return err
+	}
+
+	_, err := compileSchema(eventType)
+	return err
+}
+
+func (d *Impl) RegisterEventType(ctx context.Context, eventType entities.EventType) (*entities.EventType, error) {
+	if err := d.validateEventType(&eventType); err != nil {
+		return nil, err
+	}
+
+	get, err := d.eventTypesRepo.Get(ctx, eventType.Name)
+	if err != nil && !d.eventTypesRepo.ErrKeyNotFound(err) {
+		return nil, err
+	}
+
+	if get != nil {
+		return nil, EventTypeAlreadyExistError
+	}
+
+	// versions outlive deleted event types, so a re-created event type continues from the last version
+	versions, err := d.ListEventTypeVersions(ctx, eventType.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	eventType.Version = 1
+	if len(versions) > 0 {
+		eventType.Version = versions[len(versions)-1].Version + 1
+	}
+
+	if err := d.saveEventType(ctx, &eventType); err != nil {
+		return nil, err
+	}
+	return &eventType, nil
+}
+
+// UpdateEventType registers a new version of the event type's schema, which has to be backward compatible
+// with the current version, unless the current version's compatibility is none
+func (d *Impl) UpdateEventType(ctx context.Context, eventType entities.EventType) (*entities.EventType, error) {
+	if err := d.validateEventType(&eventType); err != nil {
+		return nil, err
+	}
+
+	current, err := d.eventTypesRepo.Get(ctx, eventType.Name)
+	if err != nil {
+		if d.eventTypesRepo.ErrKeyNotFound(err) {
+			return nil, EventTypeNotFoundError
+		}
+		return nil, err
+	}
+
+	// the compatibility of the current version applies, a change of compatibility has to be an update of its own,
+	// so that a breaking schema can not be pushed along with compatibility none
+	if eventType.CompatibilityMode() != current.CompatibilityMode() {
+		same, err := entities.SameSchema(current.Schema, eventType.Schema)
+		if err != nil {
+			return nil, err
+		}
+		if !same {
+			return nil, errors.Newf("compatibility can not be changed (%s -> %s) along with the schema, update it on its own first", current.CompatibilityMode(), eventType.CompatibilityMode())
+		}
+	}
+
+	if current.CompatibilityMode() == entities.SchemaCompatibilityBackward {
+		if err := entities.CheckBackwardCompatibility(current.Schema, eventType.Schema); err != nil {
+			return nil, err
+		}
+	}
+
+	eventType.Version = current.Version + 1
+	if err := d.saveEventType(ctx, &eventType); err != nil {
+		return nil, err
+	}
+	return &eventType, nil
+}
+
+func (d *Impl) saveEventType(ctx context.Context, eventType *entities.EventType) error {
+	if err := d.eventTypeVersionsRepo.Set(ctx, eventType.VersionKey(), eventType); err != nil {
+		return err
+	}
+	return d.eventTypesRepo.Set(ctx, eventType.Name, eventType)
+}
+
+func (d *Impl) ListEventTypes(ctx context.Context) ([]*entities.EventType, error) {
+	eventTypes, err := d.eventTypesRepo.List(ctx, ">")
+	if err != nil {
+		if errors.Is(err, jetstream.ErrNoKeysFound) {
+			return []*entities.EventType{}, nil
+		}
+		return nil, err
+	}
+	return eventTypes, nil
+}
+
+func (d *Impl) ListEventTypeVersions(ctx context.Context, name string) ([]*entities.EventType, error) {
+	versions, err := d.eventTypeVersionsRepo.List(ctx, name+".*")
+	if err != nil {
+		if errors.Is(err, jetstream.ErrNoKeysFound) {
+			return []*entities.EventType{}, nil
+		}
+		return nil, err
+	}
+
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].Version < versions[j].Version
+	})
+	return versions, nil
+}
+
+func (d *Impl) GetEventType(ctx context.Context, name string) (*entities.EventType, error) {
+	get, err := d.eventTypesRepo.Get(ctx, name)
+	if err != nil {
+		if d.eventTypesRepo.ErrKeyNotFound(err) {
+			return nil, EventTypeNotFoundError
+		}
+		return nil, err
+	}
+	return get, nil
+}
+
+func (d *Impl) DeleteEventType(ctx context.Context, name string) error {
+	return d.eventTypesRepo.Drop(ctx, name)
+}
+
+// ValidateEventData validates the event's data against the schema of its event type, a nil event type accepts any data
+func (d *Impl) ValidateEventData(eventType *entities.EventType, event *entities.Event) error {
+	if eventType == nil {
+		return nil
+	}
+
+	schema, err := d.eventSchemas.get(eventType)
+	if err != nil {
+		return err
+	}
+
+	var data any = event.Data
+	if event.Data == nil {
+		data = map[string]any{}
+	}
+
+	if err := schema.Validate(data); err != nil {
+		var ve *jsonschema.ValidationError
+		if errors.As(err, &ve) {
+			return fmt.Errorf("data does not match schema of event type (%s) version %d: %s", eventType.Name, eventType.Version, schemaViolations(ve))
+		}
+		return err
+	}
+	return nil
+}
+
+// ValidateEvent validates the event's data against its registered event type, events of unregistered types are not validated
+func (d *Impl) ValidateEvent(ctx context.Context, event *entities.Event) error {
+	eventType, err := d.GetEventType(ctx, event.EventType)
+	if err != nil {
+		if errors.Is(err, EventTypeNotFoundError) {
+			return nil
+		}
+		return err
+	}
+	return d.ValidateEventData(eventType, event)
+}
+
+// schemaViolations flattens the validation error into its leaf causes, e.g. "/cpu: expected number, but got string"
+func schemaViolations(ve *jsonschema.ValidationError) string {
+	if len(ve.Causes) == 0 {
+		location := ve.InstanceLocation
+		if location == "" {
+			location = "/"
+		}
+		return fmt.Sprintf("%s: %s", location, ve.Message)
+	}
+
+	var b bytes.Buffer
+	for i, cause := range ve.Causes {
+		if i > 0 {
+			b.WriteString("; ")
+		}
+		b.WriteString(schemaViolations(cause))
+	}
+	return b.String()
+}
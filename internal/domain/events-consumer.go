@@ -28,10 +28,14 @@ func (d MeterMap) RemoveMeter(key string) {
 	delete(d, key)
 }
 func (d *Impl) AddMeterToConsume(meter *entities.Meter) {
+	d.meterMapMu.Lock()
+	defer d.meterMapMu.Unlock()
 	d.meterMap.Add(meter)
 }
 
 func (d *Impl) RemoveMeterFromConsume(key string) {
+	d.meterMapMu.Lock()
+	defer d.meterMapMu.Unlock()
 	d.meterMap.RemoveMeter(key)
 }
 
@@ -41,7 +45,9 @@ func (d *Impl) StartConsumingEvents(ctx context.Context) error {
 		return errors.NewE(err)
 	}
 
+	d.meterMapMu.Lock()
 	d.meterMap.AddMeters(meters)
+	d.meterMapMu.Unlock()
 
 	upCh, downCh, runner := d.consumerController(ctx)
 	go runner()
@@ -52,11 +58,23 @@ func (d *Impl) StartConsumingEvents(ctx context.Context) error {
 		}
 
 		if err := func() error {
+			d.meterMapMu.Lock()
+			defer d.meterMapMu.Unlock()
+
 			for _, meter := range d.meterMap {
-				if _, ok := d.oldMeterMap[meter.Hash()]; !ok {
+				old, ok := d.oldMeterMap[meter.Hash()]
+				if !ok {
 					d.logger.Infof("new meter: (%s)(%s)", meter.Key(), meter.Hash())
 					d.oldMeterMap.Add(meter)
 					upCh <- meter
+					continue
+				}
+
+				if old.Version != meter.Version {
+					d.logger.Infof("updated meter: (%s)(%s), version %d -> %d, restarting consumer", meter.Key(), meter.Hash(), old.Version, meter.Version)
+					d.oldMeterMap.Add(meter)
+					downCh <- old
+					upCh <- meter
 				}
 			}
 
@@ -77,6 +95,32 @@ func (d *Impl) StartConsumingEvents(ctx context.Context) error {
 	}
 }
 
+// meterConsumerConfig returns the config of a meter's durable consumer, named after the meter's hash
+func meterConsumerConfig(meter *entities.Meter) msg_nats.ConsumerConfig {
+	cfg := msg_nats.ConsumerConfig{
+		Name:          meter.Hash(),
+		Durable:       meter.Hash(),
+		Description:   fmt.Sprintf("This consumer reads events of type %s, for the meter %s", meter.EventType, meter.Key()),
+		FilterSubject: fmt.Sprintf("meters.events.%s.>", meter.EventType),
+		DeliverPolicy: jetstream.DeliverAllPolicy,
+	}
+
+	if meter.BackfillFrom != nil {
+		switch meter.BackfillFrom.Mode {
+		case entities.BackfillNone:
+			cfg.DeliverPolicy = jetstream.DeliverNewPolicy
+		case entities.BackfillSinceTime:
+			cfg.DeliverPolicy = jetstream.DeliverByStartTimePolicy
+			cfg.OptStartTime = meter.BackfillFrom.Time
+		case entities.BackfillSinceSequence:
+			cfg.DeliverPolicy = jetstream.DeliverByStartSequencePolicy
+			cfg.OptStartSeq = meter.BackfillFrom.Sequence
+		}
+	}
+
+	return cfg
+}
+
 func (d *Impl) consumerController(ctx context.Context) (chan *entities.Meter, chan *entities.Meter, func()) {
 	upCh := make(chan *entities.Meter)
 	downCh := make(chan *entities.Meter)
@@ -89,24 +133,19 @@ func (d *Impl) consumerController(ctx context.Context) (chan *entities.Meter, ch
 			case <-ctx.Done():
 				return
 			case up := <-upCh:
-				go func() {
-					if _, ok := ctxMap[up.Hash()]; ok {
-						d.logger.Infof("consumer already running")
-						return
-					}
+				if _, ok := ctxMap[up.Hash()]; ok {
+					d.logger.Infof("consumer already running")
+					continue
+				}
 
-					consumerName := up.Hash()
-					ctx, cf := context.WithCancel(context.TODO())
-					ctxMap[consumerName] = cf
+				consumerName := up.Hash()
+				ctx, cf := context.WithCancel(context.TODO())
+				ctxMap[consumerName] = cf
 
+				go func() {
 					consumer, err := msg_nats.NewJetstreamConsumer(ctx, d.jc, msg_nats.JetstreamConsumerArgs{
-						Stream: d.env.MeterNatsStream,
-						ConsumerConfig: msg_nats.ConsumerConfig{
-							Name:          consumerName,
-							Durable:       consumerName,
-							Description:   "This consumer reads messages from a subject dedicated to errors that occurred when the resource was applied at the agent",
-							FilterSubject: fmt.Sprintf("meters.events.%s.>", up.EventType),
-						},
+						Stream:         d.env.MeterNatsStream,
+						ConsumerConfig: meterConsumerConfig(up),
 					})
 
 					if err != nil && errors.Is(err, jetstream.ErrStreamNotFound) {
@@ -123,13 +162,8 @@ func (d *Impl) consumerController(ctx context.Context) (chan *entities.Meter, ch
 						}
 
 						consumer, err = msg_nats.NewJetstreamConsumer(ctx, d.jc, msg_nats.JetstreamConsumerArgs{
-							Stream: d.env.MeterNatsStream,
-							ConsumerConfig: msg_nats.ConsumerConfig{
-								Name:          consumerName,
-								Durable:       consumerName,
-								Description:   "This consumer reads messages from a subject dedicated to errors that occurred when the resource was applied at the agent",
-								FilterSubject: fmt.Sprintf("meters.events.%s.>", up.EventType),
-							},
+							Stream:         d.env.MeterNatsStream,
+							ConsumerConfig: meterConsumerConfig(up),
 						})
 						if err != nil {
 							d.logger.Errorf(err, "error while creating consumer after stream creation: %v")
@@ -140,6 +174,10 @@ func (d *Impl) consumerController(ctx context.Context) (chan *entities.Meter, ch
 						return
 					}
 
+					if up.Aggregation == entities.AggTypeDuration {
+						go d.runDurationFlush(ctx, up)
+					}
+
 					if err := consumer.Consume(func(msg *types.ConsumeMsg) error {
 
 						var event entities.Event
@@ -147,6 +185,14 @@ func (d *Impl) consumerController(ctx context.Context) (chan *entities.Meter, ch
 							return err
 						}
 
+						if event.Time == "" {
+							event.Time = msg.Timestamp.UTC().Format(time.RFC3339Nano)
+						}
+
+						if up.Filter != nil && !up.Filter.Matches(event.Data) {
+							return nil
+						}
+
 						if err := d.updateReadings(ctx, up, &event); err != nil {
 							d.logger.Errorf(err, "could not update readings")
 						}
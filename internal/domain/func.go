@@ -0,0 +1,87 @@
+package domain
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+	"github.com/kloudlite/kloudmeter/internal/domain/entities"
+)
+
+// funcEnv describes the variables available to a meter's func expression
+//   - prev: value of the reading before this event (0 for the first event)
+//   - count: number of events aggregated into the reading before this event
+//   - $: data of the current event, e.g. $.cpu * $.durationSec / 3600
+//   - subject: subject of the current event
+//   - time: unix timestamp (seconds) of the current event
+type funcEnv struct {
+	Prev    float64        `expr:"prev"`
+	Count   int            `expr:"count"`
+	Data    map[string]any `expr:"$"`
+	Subject string         `expr:"subject"`
+	Time    int64          `expr:"time"`
+}
+
+func compileFunc(expression string) (*vm.Program, error) {
+	program, err := expr.Compile(expression, expr.Env(funcEnv{}), expr.AsFloat64())
+	if err != nil {
+		return nil, fmt.Errorf("invalid func expression: %w", err)
+	}
+	return program, nil
+}
+
+// funcPrograms caches compiled func expressions, keyed by the expression itself
+type funcPrograms struct {
+	sync.Mutex
+	programs map[string]*vm.Program
+}
+
+func (f *funcPrograms) get(expression string) (*vm.Program, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	if p, ok := f.programs[expression]; ok {
+		return p, nil
+	}
+
+	p, err := compileFunc(expression)
+	if err != nil {
+		return nil, err
+	}
+
+	if f.programs == nil {
+		f.programs = make(map[string]*vm.Program)
+	}
+	f.programs[expression] = p
+	return p, nil
+}
+
+func (d *Impl) evalFunc(meter *entities.Meter, prev *entities.Reading, event *entities.Event, eventTime time.Time) (float64, error) {
+	program, err := d.funcPrograms.get(meter.Func)
+	if err != nil {
+		return 0, err
+	}
+
+	env := funcEnv{
+		Data:    event.Data,
+		Subject: event.Subject,
+		Time:    eventTime.Unix(),
+	}
+	if prev != nil {
+		env.Prev = prev.Value
+		env.Count = prev.Count
+	}
+
+	out, err := expr.Run(program, env)
+	if err != nil {
+		return 0, fmt.Errorf("failed to evaluate func expression: %w", err)
+	}
+
+	v, ok := out.(float64)
+	if !ok {
+		return 0, fmt.Errorf("func expression must evaluate to a number, got %T (%v)", out, out)
+	}
+	return v, nil
+}
@@ -1,5 +1,12 @@
 package domain
 
+import (
+	"fmt"
+	"time"
+
+	"github.com/kloudlite/kloudmeter/internal/domain/entities"
+)
+
 func MeterKey(id string) string {
 	return "meter-" + id
 }
@@ -7,3 +14,15 @@ func MeterKey(id string) string {
 func EventKey(id string) string {
 	return "event-" + id
 }
+
+// ReadingKey returns the readings bucket key, of format <meter-key>.<subject>[.<segment>][.<window-bucket>]
+func ReadingKey(meterKey string, subject string, segment string, window entities.WindowSize, t time.Time) string {
+	key := fmt.Sprintf("%s.%s", meterKey, subject)
+	if segment != "" {
+		key = fmt.Sprintf("%s.%s", key, segment)
+	}
+	if window != "" {
+		key = fmt.Sprintf("%s.%s", key, window.Bucket(t))
+	}
+	return key
+}
@@ -2,50 +2,185 @@ package domain
 
 import (
 	"context"
+	"sort"
+	"sync"
 
 	"github.com/kloudlite/kloudmeter/internal/domain/entities"
 	"github.com/kloudlite/kloudmeter/internal/env"
 	"github.com/kloudlite/kloudmeter/pkg/errors"
 	"github.com/kloudlite/kloudmeter/pkg/kv"
 	"github.com/kloudlite/kloudmeter/pkg/logging"
+	msg_nats "github.com/kloudlite/kloudmeter/pkg/messaging/nats"
 	"github.com/kloudlite/kloudmeter/pkg/nats"
+	"github.com/nats-io/nats.go/jetstream"
 	"go.uber.org/fx"
 )
 
 type Impl struct {
-	meterRepo     kv.Repo[*entities.Meter]
-	readingsRepo  kv.Repo[*entities.Reading]
-	logger        logging.Logger
-	meterMap      MeterMap
-	oldMeterMap   MeterMap
-	jc            *nats.JetstreamClient
-	env           *env.Env
-	meterProducer MeterProducer
+	meterRepo             kv.Repo[*entities.Meter]
+	readingsRepo          kv.Repo[*entities.Reading]
+	meterVersionsRepo     kv.Repo[*entities.Meter]
+	durationStatesRepo    kv.Repo[*entities.DurationState]
+	eventTypesRepo        kv.Repo[*entities.EventType]
+	eventTypeVersionsRepo kv.Repo[*entities.EventType]
+	pricePlansRepo        kv.Repo[*entities.PricePlan]
+	limitsRepo            kv.Repo[*entities.Limit]
+	alertRulesRepo        kv.Repo[*entities.AlertRule]
+	alertStatesRepo       kv.Repo[*entities.Alert]
+	alertDeliveriesRepo   kv.Repo[*entities.AlertDelivery]
+	closedPeriodsRepo     kv.Repo[*entities.ClosedPeriod]
+	snapshotsRepo         kv.Repo[*entities.Reading]
+	adjustmentsRepo       kv.Repo[*entities.Adjustment]
+	cumulativePointsRepo  kv.Repo[*entities.CumulativePoint]
+	logger                logging.Logger
+	meterMap              MeterMap
+	meterMapMu            sync.Mutex
+	oldMeterMap           MeterMap
+	jc                    *nats.JetstreamClient
+	env                   *env.Env
+	meterProducer         MeterProducer
+	funcPrograms          funcPrograms
+	eventSchemas          eventSchemas
+
+	limitsCache   kvCache[*entities.Limit]
+	metersCache   kvCache[*entities.Meter]
+	readingsCache kvCache[*entities.Reading]
+
+	alertRulesCache kvCache[*entities.AlertRule]
+	// firedAlerts maps the state keys of fired alerts to when they are forgotten
+	firedAlerts sync.Map
+	alertQueue  chan string
+	// queuedAlerts are the keys of the deliveries in alertQueue, or being delivered
+	queuedAlerts sync.Map
+
+	closedPeriodsCache kvCache[*entities.ClosedPeriod]
 }
 
 func (d *Impl) ListMeters(ctx context.Context) ([]kv.Entry[*entities.Meter], error) {
 	return d.meterRepo.Entries(ctx, ">")
 }
 
-func (d *Impl) RegisterMeter(ctx context.Context, meter entities.Meter) error {
+func (d *Impl) validateMeter(meter *entities.Meter) error {
 	if err := meter.IsValid(); err != nil {
 		return err
 	}
 
+	if meter.Aggregation == entities.AggTypeFunc {
+		if _, err := compileFunc(meter.Func); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (d *Impl) RegisterMeter(ctx context.Context, meter entities.Meter) (*entities.Meter, error) {
+	if err := d.validateMeter(&meter); err != nil {
+		return nil, err
+	}
+
 	get, err := d.meterRepo.Get(ctx, meter.Key())
 	if err != nil && !d.meterRepo.ErrKeyNotFound(err) {
+		return nil, err
+	}
+
+	if get != nil {
+		return nil, MeterAlreadyExistError
+	}
+
+	// versions outlive deleted meters, so a re-created meter continues from the last version
+	versions, err := d.ListMeterVersions(ctx, meter.Key())
+	if err != nil {
+		return nil, err
+	}
+
+	meter.Version = 1
+	if len(versions) > 0 {
+		meter.Version = versions[len(versions)-1].Version + 1
+	}
+
+	if err := d.saveMeter(ctx, &meter); err != nil {
+		return nil, err
+	}
+	return &meter, nil
+}
+
+func (d *Impl) UpdateMeter(ctx context.Context, meter entities.Meter) (*entities.Meter, error) {
+	if err := d.validateMeter(&meter); err != nil {
+		return nil, err
+	}
+
+	current, err := d.meterRepo.Get(ctx, meter.Key())
+	if err != nil {
+		if d.meterRepo.ErrKeyNotFound(err) {
+			return nil, MeterNotFoundError
+		}
+		return nil, err
+	}
+
+	// meters created before versioning have no version, they are recorded as version 1
+	if current.Version == 0 {
+		current.Version = 1
+		if err := d.meterVersionsRepo.Set(ctx, current.VersionKey(), current); err != nil {
+			return nil, err
+		}
+	}
+
+	// backfill only applies when the meter's consumer is created
+	meter.BackfillFrom = current.BackfillFrom
+	meter.Version = current.Version + 1
+	if err := d.saveMeter(ctx, &meter); err != nil {
+		return nil, err
+	}
+	return &meter, nil
+}
+
+func (d *Impl) saveMeter(ctx context.Context, meter *entities.Meter) error {
+	if err := d.meterVersionsRepo.Set(ctx, meter.VersionKey(), meter); err != nil {
 		return err
 	}
+	return d.meterRepo.Set(ctx, meter.Key(), meter)
+}
 
-	if get == nil {
-		return d.meterRepo.Set(ctx, meter.Key(), &meter)
+func (d *Impl) ListMeterVersions(ctx context.Context, key string) ([]*entities.Meter, error) {
+	versions, err := d.meterVersionsRepo.List(ctx, key+".*")
+	if err != nil {
+		if errors.Is(err, jetstream.ErrNoKeysFound) {
+			return []*entities.Meter{}, nil
+		}
+		return nil, err
 	}
 
-	return MeterAlreadyExistError
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].Version < versions[j].Version
+	})
+	return versions, nil
 }
 
-func (d *Impl) DeleteMeter(ctx context.Context, id string) error {
-	return d.meterRepo.Drop(ctx, id)
+// DeleteMeter drops the meter, stops consuming its events and deletes its durable consumer, so that a re-created
+// meter starts from its own backfillFrom, instead of the position of the deleted one
+func (d *Impl) DeleteMeter(ctx context.Context, key string) error {
+	meter, err := d.meterRepo.Get(ctx, key)
+	if err != nil {
+		if d.meterRepo.ErrKeyNotFound(err) {
+			return MeterNotFoundError
+		}
+		return err
+	}
+
+	if err := d.meterRepo.Drop(ctx, key); err != nil {
+		return err
+	}
+
+	d.RemoveMeterFromConsume(meter.Hash())
+
+	if err := msg_nats.DeleteDurableConsumer(ctx, d.jc, d.env.MeterNatsStream, meter.Hash()); err != nil {
+		if errors.Is(err, jetstream.ErrConsumerNotFound) || errors.Is(err, jetstream.ErrStreamNotFound) {
+			return nil
+		}
+		return err
+	}
+	return nil
 }
 
 func (d *Impl) GetMeter(ctx context.Context, id string) (*entities.Meter, error) {
@@ -54,7 +189,7 @@ func (d *Impl) GetMeter(ctx context.Context, id string) (*entities.Meter, error)
 		return nil, err
 	}
 	if get == nil {
-		return nil, errors.New("meter not found")
+		return nil, MeterNotFoundError
 	}
 	return get, nil
 }
@@ -73,19 +208,46 @@ func (d *Impl) GetReading(ctx context.Context, key string) (*entities.Reading, e
 var Module = fx.Module("domain", fx.Provide(func(e *env.Env,
 	meterRepo kv.Repo[*entities.Meter],
 	readingsRepo kv.Repo[*entities.Reading],
+	meterVersionsRepo MeterVersionsRepo,
+	durationStatesRepo kv.Repo[*entities.DurationState],
+	eventTypesRepo kv.Repo[*entities.EventType],
+	eventTypeVersionsRepo EventTypeVersionsRepo,
+	pricePlansRepo kv.Repo[*entities.PricePlan],
+	limitsRepo kv.Repo[*entities.Limit],
+	alertRulesRepo kv.Repo[*entities.AlertRule],
+	alertStatesRepo kv.Repo[*entities.Alert],
+	alertDeliveriesRepo kv.Repo[*entities.AlertDelivery],
+	closedPeriodsRepo kv.Repo[*entities.ClosedPeriod],
+	snapshotsRepo SnapshotsRepo,
+	adjustmentsRepo kv.Repo[*entities.Adjustment],
+	cumulativePointsRepo kv.Repo[*entities.CumulativePoint],
 	logger logging.Logger,
 	jc *nats.JetstreamClient,
 	env *env.Env,
 	meterProducer MeterProducer,
 ) (Domain, error) {
 	return &Impl{
-		meterRepo:     meterRepo,
-		readingsRepo:  readingsRepo,
-		logger:        logger,
-		meterMap:      MeterMap{},
-		oldMeterMap:   MeterMap{},
-		jc:            jc,
-		env:           env,
-		meterProducer: meterProducer,
+		meterRepo:             meterRepo,
+		readingsRepo:          readingsRepo,
+		meterVersionsRepo:     meterVersionsRepo,
+		durationStatesRepo:    durationStatesRepo,
+		eventTypesRepo:        eventTypesRepo,
+		eventTypeVersionsRepo: eventTypeVersionsRepo,
+		pricePlansRepo:        pricePlansRepo,
+		limitsRepo:            limitsRepo,
+		alertRulesRepo:        alertRulesRepo,
+		alertStatesRepo:       alertStatesRepo,
+		alertDeliveriesRepo:   alertDeliveriesRepo,
+		closedPeriodsRepo:     closedPeriodsRepo,
+		snapshotsRepo:         snapshotsRepo,
+		adjustmentsRepo:       adjustmentsRepo,
+		cumulativePointsRepo:  cumulativePointsRepo,
+		alertQueue:            make(chan string, alertQueueSize),
+		logger:                logger,
+		meterMap:              MeterMap{},
+		oldMeterMap:           MeterMap{},
+		jc:                    jc,
+		env:                   env,
+		meterProducer:         meterProducer,
 	}, nil
 }))
@@ -0,0 +1,245 @@
+package domain
+
+import (
+	"fmt"
+
+	"github.com/axiomhq/hyperloglog"
+	"github.com/kloudlite/kloudmeter/internal/domain/entities"
+)
+
+// mergeReadings combines readings of a meter into one, following the meter's aggregation semantics,
+// i.e. sum of sums and counts, max of maxes, averages weighted by count, merged sketches etc.
+// func readings merge as the meter's FuncMerge declares, and can not be merged without it.
+func mergeReadings(meter *entities.Meter, readings []*entities.Reading) (*entities.Reading, error) {
+	if err := meter.Mergeable(); err != nil {
+		return nil, err
+	}
+
+	if len(readings) == 0 {
+		return nil, nil
+	}
+
+	first := readings[0]
+	acc := &entities.Reading{
+		Event:          first.Event,
+		MeterId:        first.MeterId,
+		MeterVersion:   first.MeterVersion,
+		Subject:        first.Subject,
+		Window:         first.Window,
+		WindowStart:    first.WindowStart,
+		WindowEnd:      first.WindowEnd,
+		Type:           first.Type,
+		Duplicates:     first.Duplicates,
+		Count:          first.Count,
+		Sum:            first.Sum,
+		Avg:            first.Avg,
+		Max:            first.Max,
+		Min:            first.Min,
+		Range:          first.Range,
+		Func:           first.Func,
+		Value:          first.Value,
+		ObservedAt:     first.ObservedAt,
+		Unique:         first.Unique,
+		Sketch:         first.Sketch,
+		Quantiles:      first.Quantiles,
+		Distinct:       first.Distinct,
+		Approximate:    first.Approximate,
+		DistinctValues: first.DistinctValues,
+		HLL:            first.HLL,
+	}
+
+	for _, r := range readings[1:] {
+		if err := mergeReading(meter, acc, r); err != nil {
+			return nil, err
+		}
+	}
+
+	return acc, nil
+}
+
+// mergeReading folds r into acc, it never mutates r, and replaces (instead of mutating) maps of acc,
+// as they might be shared with the readings acc was built from
+func mergeReading(meter *entities.Meter, acc *entities.Reading, r *entities.Reading) error {
+	if acc.Subject != r.Subject {
+		acc.Subject = ""
+	}
+
+	if acc.Window != r.Window {
+		acc.Window = ""
+	}
+
+	if r.WindowStart != nil && (acc.WindowStart == nil || r.WindowStart.Before(*acc.WindowStart)) {
+		acc.WindowStart = r.WindowStart
+	}
+
+	if r.WindowEnd != nil && (acc.WindowEnd == nil || r.WindowEnd.After(*acc.WindowEnd)) {
+		acc.WindowEnd = r.WindowEnd
+	}
+
+	switch meter.Aggregation {
+	case entities.AggTypeCount:
+	case entities.AggTypeSum, entities.AggTypeDuration:
+		acc.Sum += r.Sum
+
+	case entities.AggTypeAvg:
+		if acc.Count+r.Count > 0 {
+			acc.Avg = (acc.Avg*float64(acc.Count) + r.Avg*float64(r.Count)) / float64(acc.Count+r.Count)
+		}
+
+	case entities.AggTypeMax:
+		if r.Max > acc.Max {
+			acc.Max = r.Max
+		}
+
+	case entities.AggTypeMin:
+		if r.Min < acc.Min {
+			acc.Min = r.Min
+		}
+
+	case entities.AggTypeRange:
+		if r.Max > acc.Max {
+			acc.Max = r.Max
+		}
+		if r.Min < acc.Min {
+			acc.Min = r.Min
+		}
+		acc.Range = acc.Max - acc.Min
+
+	case entities.AggTypeFunc:
+		switch meter.FuncMerge {
+		case entities.FuncMergeSum:
+			acc.Value += r.Value
+		case entities.FuncMergeMax:
+			if r.Value > acc.Value {
+				acc.Value = r.Value
+			}
+		case entities.FuncMergeMin:
+			if r.Value < acc.Value {
+				acc.Value = r.Value
+			}
+		default:
+			return meter.Mergeable()
+		}
+
+	case entities.AggTypeLatest, entities.AggTypeFirst:
+		if r.ObservedAt != nil && (acc.ObservedAt == nil ||
+			(meter.Aggregation == entities.AggTypeLatest && r.ObservedAt.After(*acc.ObservedAt)) ||
+			(meter.Aggregation == entities.AggTypeFirst && r.ObservedAt.Before(*acc.ObservedAt))) {
+			acc.Value = r.Value
+			acc.ObservedAt = r.ObservedAt
+		}
+
+	case entities.AggTypeUnique:
+		unique := make(map[string]int, len(acc.Unique)+len(r.Unique))
+		for k, v := range acc.Unique {
+			unique[k] = v
+		}
+		for k, v := range r.Unique {
+			unique[k] += v
+		}
+		acc.Unique = unique
+
+	case entities.AggTypePercentile:
+		sketch, err := loadSketch(acc.Sketch, meter.SketchAccuracy())
+		if err != nil {
+			return err
+		}
+		if len(r.Sketch) > 0 {
+			if err := sketch.DecodeAndMergeWith(r.Sketch); err != nil {
+				return err
+			}
+		}
+
+		quantiles, err := sketchQuantiles(sketch, meter.Quantiles)
+		if err != nil {
+			return err
+		}
+		acc.Sketch = encodeSketch(sketch)
+		acc.Quantiles = quantiles
+
+	case entities.AggTypeCardinality:
+		if err := mergeDistinct(meter, acc, r); err != nil {
+			return err
+		}
+
+	default:
+		return fmt.Errorf("unknown aggregation type: %s", meter.Aggregation)
+	}
+
+	acc.Count += r.Count
+	acc.Duplicates += r.Duplicates
+	return nil
+}
+
+func mergeDistinct(meter *entities.Meter, acc *entities.Reading, r *entities.Reading) error {
+	settings := meter.CardinalitySettings()
+
+	if !acc.Approximate && !r.Approximate {
+		values := make(map[string]bool, len(acc.DistinctValues)+len(r.DistinctValues))
+		for v := range acc.DistinctValues {
+			values[v] = true
+		}
+		for v := range r.DistinctValues {
+			values[v] = true
+		}
+
+		if len(values) <= settings.Cap {
+			acc.DistinctValues = values
+			acc.Distinct = uint64(len(values))
+			return nil
+		}
+	}
+
+	toSketch := func(reading *entities.Reading) (*hyperloglog.Sketch, error) {
+		if reading.Approximate {
+			return loadHLL(reading.HLL, settings.Precision)
+		}
+
+		sketch, err := hyperloglog.NewSketch(settings.Precision, true)
+		if err != nil {
+			return nil, err
+		}
+		for v := range reading.DistinctValues {
+			sketch.Insert([]byte(v))
+		}
+		return sketch, nil
+	}
+
+	sketch, err := toSketch(acc)
+	if err != nil {
+		return err
+	}
+
+	other, err := toSketch(r)
+	if err != nil {
+		return err
+	}
+
+	if err := sketch.Merge(other); err != nil {
+		return err
+	}
+
+	b, err := sketch.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	acc.HLL = b
+	acc.DistinctValues = nil
+	acc.Distinct = sketch.Estimate()
+	acc.Approximate = true
+	return nil
+}
+
+// withDimensions returns a copy of the dimensions, with only the given names
+func withDimensions(dimensions map[string]string, names []string) map[string]string {
+	if len(names) == 0 {
+		return nil
+	}
+
+	result := make(map[string]string, len(names))
+	for _, name := range names {
+		result[name] = dimensions[name]
+	}
+	return result
+}
@@ -0,0 +1,155 @@
+package domain
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/kloudlite/kloudmeter/internal/domain/entities"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// metricNameSanitiser replaces the characters of meter keys and dimension names, that prometheus names do not allow
+var metricNameSanitiser = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+func metricName(meter *entities.Meter) string {
+	return "kloudmeter_" + metricNameSanitiser.ReplaceAllString(meter.Key(), "_")
+}
+
+var droppedSeriesDesc = prometheus.NewDesc(
+	"kloudmeter_metrics_dropped_series",
+	"Readings of the meter not exported, as the meter's metrics maxSeries was reached",
+	[]string{"meter"}, nil,
+)
+
+// readingsCollector exports the current readings (lifetime readings, and readings of open windows) of every meter
+// as a gauge named after the meter, from the in-memory readings cache
+type readingsCollector struct {
+	d *Impl
+}
+
+func (d *Impl) ReadingsCollector() prometheus.Collector {
+	return &readingsCollector{d: d}
+}
+
+// Describe sends no descriptors, as metrics come and go with meters, which makes the collector unchecked
+func (c *readingsCollector) Describe(chan<- *prometheus.Desc) {}
+
+func (c *readingsCollector) Collect(ch chan<- prometheus.Metric) {
+	byMeter := map[string][]*entities.Reading{}
+	for _, r := range c.d.readingsCache.values(isCurrentReading) {
+		key := fmt.Sprintf("%s.%s.%s", r.Event, r.Type, r.MeterId)
+		byMeter[key] = append(byMeter[key], r)
+	}
+
+	meters := c.d.metersCache.values(func(m *entities.Meter) bool {
+		return !m.MetricsSettings().Disabled && len(byMeter[m.Key()]) > 0
+	})
+
+	// meter keys that differ in sanitised characters only share a metric name, the first meter by key exports it,
+	// and the readings of the others are counted as dropped
+	sort.Slice(meters, func(i, j int) bool { return meters[i].Key() < meters[j].Key() })
+	names := map[string]bool{}
+
+	for _, meter := range meters {
+		name := metricName(meter)
+		if names[name] {
+			ch <- constGauge(droppedSeriesDesc, float64(len(byMeter[meter.Key()])), meter.Key())
+			continue
+		}
+		names[name] = true
+
+		c.collectMeter(ch, meter, byMeter[meter.Key()])
+	}
+}
+
+// dimensionLabels returns the label names of the meter's dimensions, prefixed with dim_ so that they never collide with
+// the fixed labels, and suffixed with a counter when dimension names are the same once sanitised
+func dimensionLabels(dimensions []string) []string {
+	labels := make([]string, 0, len(dimensions))
+	seen := map[string]bool{}
+	for _, name := range dimensions {
+		label := "dim_" + metricNameSanitiser.ReplaceAllString(name, "_")
+		for i := 2; seen[label]; i++ {
+			label = fmt.Sprintf("dim_%s_%d", metricNameSanitiser.ReplaceAllString(name, "_"), i)
+		}
+		seen[label] = true
+		labels = append(labels, label)
+	}
+	return labels
+}
+
+func (c *readingsCollector) collectMeter(ch chan<- prometheus.Metric, meter *entities.Meter, readings []*entities.Reading) {
+	dimensions := meter.DimensionNames()
+
+	labels := append([]string{"subject", "window", "segment"}, dimensionLabels(dimensions)...)
+	if meter.Aggregation == entities.AggTypePercentile {
+		labels = append(labels, "quantile")
+	}
+
+	help := meter.Description
+	if help == "" {
+		help = fmt.Sprintf("Readings of meter %s", meter.Key())
+	}
+	desc := prometheus.NewDesc(metricName(meter), help, labels, nil)
+
+	// subject readings are exported before segment ones, so that they are the last to be dropped
+	sort.Slice(readings, func(i, j int) bool {
+		a, b := readings[i], readings[j]
+		if (a.Segment == "") != (b.Segment == "") {
+			return a.Segment == ""
+		}
+		if a.Subject != b.Subject {
+			return a.Subject < b.Subject
+		}
+		if a.Window != b.Window {
+			return a.Window < b.Window
+		}
+		return a.Segment < b.Segment
+	})
+
+	maxSeries := meter.MetricsSettings().MaxSeries
+	exported, dropped := 0, 0
+
+	for _, r := range readings {
+		if exported >= maxSeries {
+			dropped++
+			continue
+		}
+		exported++
+
+		values := []string{r.Subject, string(r.Window), r.Segment}
+		for _, name := range dimensions {
+			values = append(values, r.Dimensions[name])
+		}
+
+		if meter.Aggregation == entities.AggTypePercentile {
+			for _, q := range meter.Quantiles {
+				v, ok := r.Quantiles[entities.QuantileName(q)]
+				if !ok {
+					continue
+				}
+				ch <- constGauge(desc, v, append(values, strconv.FormatFloat(q, 'f', -1, 64))...)
+			}
+			continue
+		}
+
+		v, err := entities.ReadingQuantity(meter, r, "")
+		if err != nil {
+			ch <- prometheus.NewInvalidMetric(desc, err)
+			continue
+		}
+		ch <- constGauge(desc, v, values...)
+	}
+
+	ch <- constGauge(droppedSeriesDesc, float64(dropped), meter.Key())
+}
+
+func constGauge(desc *prometheus.Desc, value float64, labelValues ...string) prometheus.Metric {
+	m, err := prometheus.NewConstMetric(desc, prometheus.GaugeValue, value, labelValues...)
+	if err != nil {
+		return prometheus.NewInvalidMetric(desc, err)
+	}
+	return m
+}
@@ -0,0 +1,73 @@
+package domain
+
+import (
+	"context"
+	"time"
+
+	"github.com/kloudlite/kloudmeter/internal/domain/entities"
+	"github.com/kloudlite/kloudmeter/pkg/errors"
+)
+
+// CumulativeDelta converts a point of a cumulative series into the deltas of its values since the previous point of
+// the series, and records it as the series' latest point with compare-and-swap. It returns false for points that
+// carry no usage to count:
+//   - points before the latest one, retries of the latest one get its deltas again
+//   - the first point seen of a series that started before OtlpSeriesTTL (or at an unknown time), as its previous
+//     point may have expired, and its total would be counted again
+//
+// A point with a later start time (the series was reset), or a monotonic one whose values went down, counts its totals
+func (d *Impl) CumulativeDelta(ctx context.Context, series string, point *entities.CumulativePoint, monotonic bool) (map[string]float64, bool, error) {
+	for attempt := 1; ; attempt++ {
+		prev, revision, err := d.cumulativePointsRepo.GetWithRevision(ctx, series)
+		if err != nil && !d.cumulativePointsRepo.ErrKeyNotFound(err) {
+			return nil, false, err
+		}
+		exists := err == nil
+
+		next := &entities.CumulativePoint{StartTime: point.StartTime, Time: point.Time, Values: point.Values}
+
+		switch {
+		case !exists:
+			if point.StartTime != 0 && point.StartTime > uint64(time.Now().Add(-d.env.OtlpSeriesTTL).UnixNano()) {
+				next.Deltas = point.Values
+			}
+
+		case point.StartTime < prev.StartTime || (point.StartTime == prev.StartTime && point.Time < prev.Time):
+			return nil, false, nil
+
+		case point.StartTime == prev.StartTime && point.Time == prev.Time:
+			return prev.Deltas, prev.Deltas != nil, nil
+
+		case point.StartTime > prev.StartTime:
+			next.Deltas = point.Values
+
+		default:
+			next.Deltas = make(map[string]float64, len(point.Values))
+			for name, v := range point.Values {
+				next.Deltas[name] = v - prev.Values[name]
+				if monotonic && v < prev.Values[name] {
+					next.Deltas = point.Values
+					break
+				}
+			}
+		}
+
+		if exists {
+			_, err = d.cumulativePointsRepo.Update(ctx, series, next, revision)
+		} else {
+			_, err = d.cumulativePointsRepo.Create(ctx, series, next)
+		}
+
+		if err == nil {
+			return next.Deltas, next.Deltas != nil, nil
+		}
+
+		if !d.cumulativePointsRepo.ErrRevisionMismatch(err) {
+			return nil, false, err
+		}
+
+		if attempt >= maxUpsertAttempts {
+			return nil, false, errors.NewEf(err, "cumulative series (%s) kept changing concurrently, gave up after %d attempts", series, attempt)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/kloudlite/kloudmeter/internal/domain/entities"
+	"github.com/kloudlite/kloudmeter/internal/env"
+	"github.com/kloudlite/kloudmeter/pkg/kv"
+)
+
+var (
+	errMemKeyNotFound      = errors.New("key not found")
+	errMemRevisionMismatch = errors.New("revision mismatch")
+)
+
+// memRepo keeps values with their revisions in memory, for the compare-and-swap methods of kv.Repo
+type memRepo[T any] struct {
+	kv.Repo[T]
+	values    map[string]T
+	revisions map[string]uint64
+}
+
+func newMemRepo[T any]() *memRepo[T] {
+	return &memRepo[T]{values: map[string]T{}, revisions: map[string]uint64{}}
+}
+
+func (r *memRepo[T]) GetWithRevision(_ context.Context, key string) (T, uint64, error) {
+	v, ok := r.values[key]
+	if !ok {
+		return v, 0, errMemKeyNotFound
+	}
+	return v, r.revisions[key], nil
+}
+
+func (r *memRepo[T]) Create(_ context.Context, key string, value T) (uint64, error) {
+	if _, ok := r.values[key]; ok {
+		return 0, errMemRevisionMismatch
+	}
+	r.values[key] = value
+	r.revisions[key] = 1
+	return 1, nil
+}
+
+func (r *memRepo[T]) Update(_ context.Context, key string, value T, revision uint64) (uint64, error) {
+	if r.revisions[key] != revision {
+		return 0, errMemRevisionMismatch
+	}
+	r.values[key] = value
+	r.revisions[key]++
+	return r.revisions[key], nil
+}
+
+func (r *memRepo[T]) ErrKeyNotFound(err error) bool {
+	return errors.Is(err, errMemKeyNotFound)
+}
+
+func (r *memRepo[T]) ErrRevisionMismatch(err error) bool {
+	return errors.Is(err, errMemRevisionMismatch)
+}
+
+func TestCumulativeDelta(t *testing.T) {
+	recent := uint64(time.Now().Add(-time.Hour).UnixNano())
+	old := uint64(time.Now().Add(-48 * time.Hour).UnixNano())
+
+	type point struct {
+		startTime uint64
+		time      uint64
+		values    map[string]float64
+		monotonic bool
+
+		deltas map[string]float64
+		ok     bool
+	}
+
+	tests := []struct {
+		name   string
+		points []point
+	}{
+		{
+			name: "first point of a recent series counts its totals",
+			points: []point{
+				{startTime: recent, time: recent + 1, values: map[string]float64{"value": 10}, monotonic: true, deltas: map[string]float64{"value": 10}, ok: true},
+			},
+		},
+		{
+			name: "first point of an old series is not counted",
+			points: []point{
+				{startTime: old, time: old + 1, values: map[string]float64{"value": 10}, monotonic: true},
+				{startTime: old, time: old + 2, values: map[string]float64{"value": 15}, monotonic: true, deltas: map[string]float64{"value": 5}, ok: true},
+			},
+		},
+		{
+			name: "first point without a start time is not counted",
+			points: []point{
+				{time: recent, values: map[string]float64{"value": 10}, monotonic: true},
+			},
+		},
+		{
+			name: "later points count the difference",
+			points: []point{
+				{startTime: recent, time: recent + 1, values: map[string]float64{"count": 2, "sum": 7}, monotonic: true, deltas: map[string]float64{"count": 2, "sum": 7}, ok: true},
+				{startTime: recent, time: recent + 2, values: map[string]float64{"count": 5, "sum": 10}, monotonic: true, deltas: map[string]float64{"count": 3, "sum": 3}, ok: true},
+			},
+		},
+		{
+			name: "retries get the same deltas",
+			points: []point{
+				{startTime: recent, time: recent + 1, values: map[string]float64{"value": 10}, monotonic: true, deltas: map[string]float64{"value": 10}, ok: true},
+				{startTime: recent, time: recent + 2, values: map[string]float64{"value": 15}, monotonic: true, deltas: map[string]float64{"value": 5}, ok: true},
+				{startTime: recent, time: recent + 2, values: map[string]float64{"value": 15}, monotonic: true, deltas: map[string]float64{"value": 5}, ok: true},
+			},
+		},
+		{
+			name: "points before the latest are skipped",
+			points: []point{
+				{startTime: recent, time: recent + 2, values: map[string]float64{"value": 15}, monotonic: true, deltas: map[string]float64{"value": 15}, ok: true},
+				{startTime: recent, time: recent + 1, values: map[string]float64{"value": 10}, monotonic: true},
+				{startTime: recent, time: recent + 3, values: map[string]float64{"value": 18}, monotonic: true, deltas: map[string]float64{"value": 3}, ok: true},
+			},
+		},
+		{
+			name: "a reset series counts its totals",
+			points: []point{
+				{startTime: recent, time: recent + 2, values: map[string]float64{"value": 15}, monotonic: true, deltas: map[string]float64{"value": 15}, ok: true},
+				{startTime: recent + 3, time: recent + 4, values: map[string]float64{"value": 4}, monotonic: true, deltas: map[string]float64{"value": 4}, ok: true},
+			},
+		},
+		{
+			name: "a monotonic series that went down counts its totals",
+			points: []point{
+				{startTime: recent, time: recent + 1, values: map[string]float64{"value": 15}, monotonic: true, deltas: map[string]float64{"value": 15}, ok: true},
+				{startTime: recent, time: recent + 2, values: map[string]float64{"value": 4}, monotonic: true, deltas: map[string]float64{"value": 4}, ok: true},
+			},
+		},
+		{
+			name: "a non monotonic series that went down counts a negative delta",
+			points: []point{
+				{startTime: recent, time: recent + 1, values: map[string]float64{"value": 15}, deltas: map[string]float64{"value": 15}, ok: true},
+				{startTime: recent, time: recent + 2, values: map[string]float64{"value": 4}, deltas: map[string]float64{"value": -11}, ok: true},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &Impl{
+				cumulativePointsRepo: newMemRepo[*entities.CumulativePoint](),
+				env:                  &env.Env{OtlpSeriesTTL: 24 * time.Hour},
+			}
+
+			for i, p := range tt.points {
+				deltas, ok, err := d.CumulativeDelta(context.Background(), "series", &entities.CumulativePoint{StartTime: p.startTime, Time: p.time, Values: p.values}, p.monotonic)
+				if err != nil {
+					t.Fatalf("point %d: CumulativeDelta() error = %v", i, err)
+				}
+				if ok != p.ok || (ok && !reflect.DeepEqual(deltas, p.deltas)) {
+					t.Errorf("point %d: CumulativeDelta() = %v, %v, want %v, %v", i, deltas, ok, p.deltas, p.ok)
+				}
+			}
+		})
+	}
+}
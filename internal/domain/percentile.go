@@ -0,0 +1,55 @@
+package domain
+
+import (
+	"github.com/DataDog/sketches-go/ddsketch"
+	"github.com/DataDog/sketches-go/ddsketch/store"
+	"github.com/kloudlite/kloudmeter/internal/domain/entities"
+)
+
+// loadSketch decodes a reading's sketch, or creates an empty one when the reading has none yet
+func loadSketch(encoded []byte, relativeAccuracy float64) (*ddsketch.DDSketch, error) {
+	if len(encoded) == 0 {
+		return ddsketch.NewDefaultDDSketch(relativeAccuracy)
+	}
+	return ddsketch.DecodeDDSketch(encoded, store.DefaultProvider, nil)
+}
+
+func encodeSketch(sketch *ddsketch.DDSketch) []byte {
+	var b []byte
+	sketch.Encode(&b, false)
+	return b
+}
+
+func sketchQuantiles(sketch *ddsketch.DDSketch, quantiles []float64) (map[string]float64, error) {
+	values, err := sketch.GetValuesAtQuantiles(quantiles)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]float64, len(quantiles))
+	for i, q := range quantiles {
+		result[entities.QuantileName(q)] = values[i]
+	}
+	return result, nil
+}
+
+// addToSketch records val into the reading's sketch, and refreshes the quantiles exposed by the meter
+func addToSketch(reading *entities.Reading, meter *entities.Meter, val float64) error {
+	sketch, err := loadSketch(reading.Sketch, meter.SketchAccuracy())
+	if err != nil {
+		return err
+	}
+
+	if err := sketch.Add(val); err != nil {
+		return err
+	}
+
+	quantiles, err := sketchQuantiles(sketch, meter.Quantiles)
+	if err != nil {
+		return err
+	}
+
+	reading.Sketch = encodeSketch(sketch)
+	reading.Quantiles = quantiles
+	return nil
+}
@@ -0,0 +1,293 @@
+package domain
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kloudlite/kloudmeter/internal/domain/entities"
+	"github.com/kloudlite/kloudmeter/pkg/errors"
+	"github.com/kloudlite/kloudmeter/pkg/functions"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// StartPeriodsCache mirrors closed periods in memory, so that every reading update can check its period without hitting KV
+func (d *Impl) StartPeriodsCache(ctx context.Context) error {
+	go watchCache(ctx, d, "closed periods", d.closedPeriodsRepo, &d.closedPeriodsCache, nil)
+	return nil
+}
+
+// ClosePeriod freezes the readings of an ended window of the meter into the snapshots bucket. The period is marked
+// as closing first, so that late events are diverted into adjustments, and every reading is marked closed with
+// compare-and-swap before it is copied, so that updates racing with the close either make it into the snapshot,
+// or find the reading closed and are recorded as adjustments. A close that failed half way is resumed by closing
+// the period again
+func (d *Impl) ClosePeriod(ctx context.Context, query PeriodQuery) (*entities.ClosedPeriod, error) {
+	if err := query.Window.IsValid(); err != nil {
+		return nil, err
+	}
+
+	meter, err := d.meterRepo.Get(ctx, query.MeterKey)
+	if err != nil {
+		if d.meterRepo.ErrKeyNotFound(err) {
+			return nil, MeterNotFoundError
+		}
+		return nil, err
+	}
+
+	if !meter.HasWindow(query.Window) {
+		return nil, errors.Newf("meter (%s) keeps no readings of window %q", meter.Key(), query.Window)
+	}
+
+	period := &entities.ClosedPeriod{
+		Meter:        meter.Key(),
+		MeterVersion: meter.Version,
+		Window:       query.Window,
+		WindowStart:  query.Window.Start(query.At),
+		WindowEnd:    query.Window.Next(query.At),
+		Status:       entities.PeriodClosing,
+	}
+
+	if period.WindowEnd.After(time.Now()) {
+		return nil, errors.Newf("period %s has not ended yet", period.Key())
+	}
+
+	revision, err := d.closedPeriodsRepo.Create(ctx, period.Key(), period)
+	if err != nil {
+		if !d.closedPeriodsRepo.ErrRevisionMismatch(err) {
+			return nil, err
+		}
+
+		current, rev, err := d.closedPeriodsRepo.GetWithRevision(ctx, period.Key())
+		if err != nil {
+			return nil, err
+		}
+
+		if current.Status == entities.PeriodClosed {
+			return nil, PeriodAlreadyClosedError
+		}
+		period, revision = current, rev
+	}
+
+	readings := make(map[string]*entities.Reading)
+	bucket := "." + period.Window.Bucket(period.WindowStart)
+
+	// readings created during the first pass, by upserts that checked the period before it was marked closing,
+	// are picked up by the second
+	for pass := 0; pass < 2; pass++ {
+		keys, err := d.readingsRepo.Keys(ctx, period.Meter+".>")
+		if err != nil && !errors.Is(err, jetstream.ErrNoKeysFound) {
+			return nil, err
+		}
+
+		for _, key := range keys {
+			if _, ok := readings[key]; ok || !strings.HasSuffix(key, bucket) {
+				continue
+			}
+
+			reading, err := d.closeReading(ctx, period, key)
+			if err != nil {
+				return nil, err
+			}
+			if reading == nil {
+				continue
+			}
+
+			// the dedupe history is of no use once the period is closed
+			reading = reading.WithoutHistory()
+			if err := d.snapshotsRepo.Set(ctx, key, reading); err != nil {
+				return nil, err
+			}
+			readings[key] = reading
+		}
+	}
+
+	checksum, err := entities.SnapshotChecksum(readings)
+	if err != nil {
+		return nil, err
+	}
+
+	period.Status = entities.PeriodClosed
+	period.Readings = len(readings)
+	period.Checksum = checksum
+	period.ClosedAt = functions.New(time.Now().UTC())
+
+	if _, err := d.closedPeriodsRepo.Update(ctx, period.Key(), period, revision); err != nil {
+		if d.closedPeriodsRepo.ErrRevisionMismatch(err) {
+			return nil, errors.Newf("period %s was closed concurrently", period.Key())
+		}
+		return nil, err
+	}
+	return period, nil
+}
+
+// closeReading marks the period's reading at key as closed, retrying on concurrent updates. It returns nil
+// for readings that do not belong to the period
+func (d *Impl) closeReading(ctx context.Context, period *entities.ClosedPeriod, key string) (*entities.Reading, error) {
+	for attempt := 1; ; attempt++ {
+		reading, revision, err := d.readingsRepo.GetWithRevision(ctx, key)
+		if err != nil {
+			if d.readingsRepo.ErrKeyNotFound(err) {
+				return nil, nil
+			}
+			return nil, err
+		}
+
+		if !period.Contains(reading) {
+			return nil, nil
+		}
+
+		if reading.Closed {
+			return reading, nil
+		}
+
+		reading.Closed = true
+		_, err = d.readingsRepo.Update(ctx, key, reading, revision)
+		if err == nil {
+			return reading, nil
+		}
+
+		if !d.readingsRepo.ErrRevisionMismatch(err) || attempt >= maxUpsertAttempts {
+			return nil, err
+		}
+	}
+}
+
+func (d *Impl) ListClosedPeriods(ctx context.Context, meterKey string) ([]*entities.ClosedPeriod, error) {
+	pattern := ">"
+	if meterKey != "" {
+		pattern = meterKey + ".>"
+	}
+
+	periods, err := d.closedPeriodsRepo.List(ctx, pattern)
+	if err != nil {
+		if errors.Is(err, jetstream.ErrNoKeysFound) {
+			return []*entities.ClosedPeriod{}, nil
+		}
+		return nil, err
+	}
+
+	sort.Slice(periods, func(i, j int) bool {
+		if periods[i].Meter != periods[j].Meter {
+			return periods[i].Meter < periods[j].Meter
+		}
+		return periods[i].WindowStart.Before(periods[j].WindowStart)
+	})
+	return periods, nil
+}
+
+func (d *Impl) getClosedPeriod(ctx context.Context, query PeriodQuery) (*entities.ClosedPeriod, error) {
+	if err := query.Window.IsValid(); err != nil {
+		return nil, err
+	}
+
+	period, err := d.closedPeriodsRepo.Get(ctx, entities.PeriodKey(query.MeterKey, query.Window, query.At))
+	if err != nil {
+		if d.closedPeriodsRepo.ErrKeyNotFound(err) {
+			return nil, PeriodNotFoundError
+		}
+		return nil, err
+	}
+	return period, nil
+}
+
+// GetSnapshot returns the frozen readings of a closed period, verified against the period's checksum
+func (d *Impl) GetSnapshot(ctx context.Context, query PeriodQuery) (*entities.UsageSnapshot, error) {
+	period, err := d.getClosedPeriod(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	if period.Status != entities.PeriodClosed {
+		return nil, errors.Newf("period %s is still closing", period.Key())
+	}
+
+	entries, err := d.snapshotsRepo.Entries(ctx, period.Meter+".>")
+	if err != nil && !errors.Is(err, jetstream.ErrNoKeysFound) {
+		return nil, err
+	}
+
+	snapshot := &entities.UsageSnapshot{Period: period, Readings: make(map[string]*entities.Reading)}
+	for _, entry := range entries {
+		if period.Contains(entry.Value) {
+			snapshot.Readings[entry.Key] = entry.Value
+		}
+	}
+
+	checksum, err := entities.SnapshotChecksum(snapshot.Readings)
+	if err != nil {
+		return nil, err
+	}
+	snapshot.Verified = checksum == period.Checksum
+
+	return snapshot, nil
+}
+
+// ListAdjustments returns the adjustments recorded for a closed period, oldest first
+func (d *Impl) ListAdjustments(ctx context.Context, query PeriodQuery) ([]*entities.Adjustment, error) {
+	period, err := d.getClosedPeriod(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	adjustments, err := d.adjustmentsRepo.List(ctx, period.Key()+".*")
+	if err != nil {
+		if errors.Is(err, jetstream.ErrNoKeysFound) {
+			return []*entities.Adjustment{}, nil
+		}
+		return nil, err
+	}
+
+	sort.Slice(adjustments, func(i, j int) bool {
+		return adjustments[i].ReceivedAt.Before(adjustments[j].ReceivedAt)
+	})
+	return adjustments, nil
+}
+
+// cachedClosedPeriod returns the closed (or closing) period, or nil when it is open. Until the cache is synced, it falls back to KV
+func (d *Impl) cachedClosedPeriod(ctx context.Context, key string) (*entities.ClosedPeriod, error) {
+	period, ok, synced := d.closedPeriodsCache.get(key)
+	if ok || synced {
+		return period, nil
+	}
+	return d.lookupClosedPeriod(ctx, key)
+}
+
+// lookupClosedPeriod reads the closed (or closing) period from KV, or nil when it is open
+func (d *Impl) lookupClosedPeriod(ctx context.Context, key string) (*entities.ClosedPeriod, error) {
+	period, err := d.closedPeriodsRepo.Get(ctx, key)
+	if err != nil {
+		if d.closedPeriodsRepo.ErrKeyNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return period, nil
+}
+
+// recordAdjustment diverts an update of a closed period's reading into an adjustment. Its id is derived from the
+// reading and the event (or the accrual time), so redeliveries overwrite the same adjustment
+func (d *Impl) recordAdjustment(ctx context.Context, period *entities.ClosedPeriod, values upsertValues) error {
+	source := values.appliedId()
+	if source == "" {
+		source = values.eventTime.Format(time.RFC3339Nano)
+	}
+
+	adjustment := &entities.Adjustment{
+		Id:         uuid.NewSHA1(uuid.NameSpaceOID, []byte(fmt.Sprintf("%s/%s", values.key, source))).String(),
+		Period:     period.Key(),
+		Meter:      values.meter.Key(),
+		Subject:    values.subject,
+		Reading:    values.key,
+		Event:      values.event,
+		Amount:     values.amount,
+		EventTime:  values.eventTime,
+		ReceivedAt: time.Now().UTC(),
+	}
+
+	d.logger.Infof("period %s is closed, recording adjustment (%s) for reading (%s)", period.Key(), adjustment.Id, values.key)
+	return d.adjustmentsRepo.Set(ctx, adjustment.Key(), adjustment)
+}
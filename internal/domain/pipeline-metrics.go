@@ -0,0 +1,19 @@
+package domain
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	updateReadingsDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "kloudmeter_update_readings_duration_seconds",
+		Help:    "Time taken to apply an event to the readings of a meter, by meter key",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"meter"})
+
+	deadLetterEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "kloudmeter_dead_letter_events_total",
+		Help: "Events published to the event-errors subjects, as they failed to update readings, by event type",
+	}, []string{"event_type"})
+)
@@ -0,0 +1,146 @@
+package domain
+
+import (
+	"context"
+
+	"github.com/kloudlite/kloudmeter/internal/domain/entities"
+	"github.com/kloudlite/kloudmeter/pkg/errors"
+	"github.com/kloudlite/kloudmeter/pkg/functions"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+func (d *Impl) validatePricePlan(ctx context.Context, plan *entities.PricePlan) error {
+	if err := plan.IsValid(); err != nil {
+		return err
+	}
+
+	meter, err := d.meterRepo.Get(ctx, plan.Meter)
+	if err != nil {
+		if d.meterRepo.ErrKeyNotFound(err) {
+			return MeterNotFoundError
+		}
+		return err
+	}
+	return meter.ValidateQuantity(plan.Quantity)
+}
+
+func (d *Impl) CreatePricePlan(ctx context.Context, plan entities.PricePlan) (*entities.PricePlan, error) {
+	if err := d.validatePricePlan(ctx, &plan); err != nil {
+		return nil, err
+	}
+
+	get, err := d.pricePlansRepo.Get(ctx, plan.Id)
+	if err != nil && !d.pricePlansRepo.ErrKeyNotFound(err) {
+		return nil, err
+	}
+
+	if get != nil {
+		return nil, PricePlanAlreadyExistError
+	}
+
+	if err := d.pricePlansRepo.Set(ctx, plan.Id, &plan); err != nil {
+		return nil, err
+	}
+	return &plan, nil
+}
+
+func (d *Impl) UpdatePricePlan(ctx context.Context, plan entities.PricePlan) (*entities.PricePlan, error) {
+	if err := d.validatePricePlan(ctx, &plan); err != nil {
+		return nil, err
+	}
+
+	if _, err := d.GetPricePlan(ctx, plan.Id); err != nil {
+		return nil, err
+	}
+
+	if err := d.pricePlansRepo.Set(ctx, plan.Id, &plan); err != nil {
+		return nil, err
+	}
+	return &plan, nil
+}
+
+func (d *Impl) ListPricePlans(ctx context.Context) ([]*entities.PricePlan, error) {
+	plans, err := d.pricePlansRepo.List(ctx, ">")
+	if err != nil {
+		if errors.Is(err, jetstream.ErrNoKeysFound) {
+			return []*entities.PricePlan{}, nil
+		}
+		return nil, err
+	}
+	return plans, nil
+}
+
+func (d *Impl) GetPricePlan(ctx context.Context, id string) (*entities.PricePlan, error) {
+	get, err := d.pricePlansRepo.Get(ctx, id)
+	if err != nil {
+		if d.pricePlansRepo.ErrKeyNotFound(err) {
+			return nil, PricePlanNotFoundError
+		}
+		return nil, err
+	}
+	return get, nil
+}
+
+func (d *Impl) DeletePricePlan(ctx context.Context, id string) error {
+	return d.pricePlansRepo.Drop(ctx, id)
+}
+
+// ComputeCharge prices the subject's reading of the plan's meter, for the window containing query.At
+// (or the lifetime reading, without a window). Subjects without a reading are charged for a quantity of 0
+func (d *Impl) ComputeCharge(ctx context.Context, query ChargeQuery) (*entities.Charge, error) {
+	plan, err := d.GetPricePlan(ctx, query.PricePlanId)
+	if err != nil {
+		return nil, err
+	}
+
+	meter, err := d.meterRepo.Get(ctx, plan.Meter)
+	if err != nil {
+		if d.meterRepo.ErrKeyNotFound(err) {
+			return nil, MeterNotFoundError
+		}
+		return nil, err
+	}
+
+	if query.Window != "" {
+		if err := query.Window.IsValid(); err != nil {
+			return nil, err
+		}
+	}
+
+	if !meter.HasWindow(query.Window) {
+		if query.Window == "" {
+			return nil, errors.Newf("meter (%s) keeps no lifetime readings, window is required", meter.Key())
+		}
+		return nil, errors.Newf("meter (%s) keeps no readings of window %q", meter.Key(), query.Window)
+	}
+
+	reading, err := d.readingsRepo.Get(ctx, ReadingKey(meter.Key(), query.Subject, "", query.Window, query.At))
+	if err != nil && !d.readingsRepo.ErrKeyNotFound(err) {
+		return nil, err
+	}
+
+	quantity, err := plan.ReadingQuantity(meter, reading)
+	if err != nil {
+		return nil, err
+	}
+
+	amount, lines := plan.Price(quantity)
+
+	charge := &entities.Charge{
+		PricePlan: plan.Id,
+		Meter:     plan.Meter,
+		Subject:   query.Subject,
+		Window:    query.Window,
+		Currency:  plan.Currency,
+		Quantity:  quantity,
+		Amount:    amount,
+		Lines:     lines,
+	}
+
+	if query.Window != "" {
+		charge.WindowStart = functions.New(query.Window.Start(query.At))
+		charge.WindowEnd = functions.New(query.Window.Next(query.At))
+	}
+
+	return charge, nil
+}
@@ -0,0 +1,279 @@
+package domain
+
+import (
+	"context"
+	"encoding/base64"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kloudlite/kloudmeter/internal/domain/entities"
+	"github.com/kloudlite/kloudmeter/pkg/errors"
+	"github.com/kloudlite/kloudmeter/pkg/kv"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+const (
+	defaultQueryLimit = 100
+	maxQueryLimit     = 1000
+)
+
+const (
+	SortByKey             = "key"
+	SortByKeyDesc         = "-key"
+	SortByWindowStart     = "windowStart"
+	SortByWindowStartDesc = "-windowStart"
+)
+
+// queriedKey is a reading key that matched a ReadingsQuery, with the window start parsed from its bucket
+type queriedKey struct {
+	key         string
+	windowStart time.Time
+}
+
+// readingsKeyMatcher narrows a ReadingsQuery down to a KV key pattern, and filters the keys that the pattern matches
+// but the query does not select, e.g. lifetime readings of a single dimension matching <meter-key>.*.* for windowed ones
+type readingsKeyMatcher struct {
+	meterKey  string
+	pattern   string
+	segmented bool
+	window    entities.WindowSize
+	from      time.Time
+	to        time.Time
+}
+
+func newReadingsKeyMatcher(meter *entities.Meter, query ReadingsQuery) (*readingsKeyMatcher, error) {
+	tokens := []string{meter.Key(), "*"}
+	if query.Subject != "" {
+		tokens[1] = query.Subject
+	}
+
+	switch {
+	case query.Segment == "*":
+		for range meter.DimensionNames() {
+			tokens = append(tokens, "*")
+		}
+
+	case query.Segment != "":
+		tokens = append(tokens, strings.Split(query.Segment, ".")...)
+
+	case len(query.Dimensions) > 0:
+		for name := range query.Dimensions {
+			if _, ok := meter.GroupBy[name]; !ok {
+				return nil, errors.Newf("meter has no dimension %q", name)
+			}
+		}
+
+		for _, name := range meter.DimensionNames() {
+			if v, ok := query.Dimensions[name]; ok {
+				tokens = append(tokens, entities.DimensionToken(name, v))
+				continue
+			}
+			tokens = append(tokens, "*")
+		}
+	}
+
+	m := &readingsKeyMatcher{
+		meterKey:  meter.Key(),
+		segmented: len(tokens) > 2 || query.Segment != "",
+		window:    query.Window,
+		from:      query.From,
+		to:        query.To,
+	}
+
+	if m.segmented && len(meter.GroupBy) == 0 {
+		return nil, errors.Newf("meter (%s) has no dimensions", meter.Key())
+	}
+
+	if query.Window != "" {
+		if err := query.Window.IsValid(); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, "*")
+		if !query.From.IsZero() {
+			m.from = query.Window.Start(query.From)
+		}
+	}
+
+	m.pattern = strings.Join(tokens, ".")
+	return m, nil
+}
+
+func (m *readingsKeyMatcher) match(key string) (queriedKey, bool) {
+	rest, ok := strings.CutPrefix(key, m.meterKey+".")
+	if !ok {
+		return queriedKey{}, false
+	}
+
+	// subjects never contain "=", while every dimension token does
+	tokens := strings.Split(rest, ".")
+	segment := tokens[1:]
+	if m.window != "" {
+		if len(tokens) < 2 {
+			return queriedKey{}, false
+		}
+		segment = tokens[1 : len(tokens)-1]
+	}
+
+	if m.segmented != (len(segment) > 0) {
+		return queriedKey{}, false
+	}
+
+	for _, token := range segment {
+		if !strings.Contains(token, "=") {
+			return queriedKey{}, false
+		}
+	}
+
+	result := queriedKey{key: key}
+	if m.window == "" {
+		return result, true
+	}
+
+	start, err := m.window.ParseBucket(tokens[len(tokens)-1])
+	if err != nil {
+		return queriedKey{}, false
+	}
+
+	if (!m.from.IsZero() && start.Before(m.from)) || (!m.to.IsZero() && start.After(m.to)) {
+		return queriedKey{}, false
+	}
+
+	result.windowStart = start
+	return result, true
+}
+
+// cursor encodes the key of the last reading of a page, along with the key pattern of the query it belongs to
+func (m *readingsKeyMatcher) cursor(key string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(m.pattern + "\x00" + key))
+}
+
+// after decodes a cursor of the same query back into the key of the last reading of the previous page
+func (m *readingsKeyMatcher) after(cursor string) (queriedKey, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return queriedKey{}, errors.New("invalid cursor")
+	}
+
+	pattern, key, ok := strings.Cut(string(b), "\x00")
+	if !ok || pattern != m.pattern {
+		return queriedKey{}, errors.New("invalid cursor, it belongs to a different query")
+	}
+
+	k, ok := m.match(key)
+	if !ok {
+		return queriedKey{}, errors.New("invalid cursor")
+	}
+	return k, nil
+}
+
+func readingsLess(sortBy string) (func(a, b queriedKey) bool, error) {
+	switch sortBy {
+	case "", SortByKey:
+		return func(a, b queriedKey) bool { return a.key < b.key }, nil
+	case SortByKeyDesc:
+		return func(a, b queriedKey) bool { return a.key > b.key }, nil
+	case SortByWindowStart:
+		return func(a, b queriedKey) bool {
+			if !a.windowStart.Equal(b.windowStart) {
+				return a.windowStart.Before(b.windowStart)
+			}
+			return a.key < b.key
+		}, nil
+	case SortByWindowStartDesc:
+		return func(a, b queriedKey) bool {
+			if !a.windowStart.Equal(b.windowStart) {
+				return a.windowStart.After(b.windowStart)
+			}
+			return a.key > b.key
+		}, nil
+	}
+	return nil, errors.Newf("unknown sort %q, must be one of key, -key, windowStart, -windowStart", sortBy)
+}
+
+// QueryReadings returns a page of the readings that the query selects. Keys are listed without their values,
+// using the narrowest wildcard pattern of the query, and only the readings of the page are read from KV.
+// The cursor is the opaque key of the last reading of the previous page, so pages stay stable while readings are added
+func (d *Impl) QueryReadings(ctx context.Context, query ReadingsQuery) (*ReadingsPage, error) {
+	if query.MeterKey == "" {
+		return nil, errors.New("meter is required")
+	}
+
+	if query.Limit <= 0 {
+		query.Limit = defaultQueryLimit
+	}
+
+	if query.Limit > maxQueryLimit {
+		return nil, errors.Newf("limit can not be more than %d", maxQueryLimit)
+	}
+
+	less, err := readingsLess(query.Sort)
+	if err != nil {
+		return nil, err
+	}
+
+	meter, err := d.meterRepo.Get(ctx, query.MeterKey)
+	if err != nil {
+		if d.meterRepo.ErrKeyNotFound(err) {
+			return nil, MeterNotFoundError
+		}
+		return nil, err
+	}
+
+	matcher, err := newReadingsKeyMatcher(meter, query)
+	if err != nil {
+		return nil, err
+	}
+
+	var after *queriedKey
+	if query.Cursor != "" {
+		k, err := matcher.after(query.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		after = &k
+	}
+
+	page := &ReadingsPage{Readings: []kv.Entry[*entities.Reading]{}}
+
+	keys, err := d.readingsRepo.Keys(ctx, matcher.pattern)
+	if err != nil {
+		if errors.Is(err, jetstream.ErrNoKeysFound) {
+			return page, nil
+		}
+		return nil, err
+	}
+
+	matched := make([]queriedKey, 0, len(keys))
+	for _, key := range keys {
+		if k, ok := matcher.match(key); ok {
+			matched = append(matched, k)
+		}
+	}
+
+	sort.Slice(matched, func(i, j int) bool { return less(matched[i], matched[j]) })
+
+	if after != nil {
+		i := sort.Search(len(matched), func(i int) bool { return less(*after, matched[i]) })
+		matched = matched[i:]
+	}
+
+	if len(matched) > query.Limit {
+		page.NextCursor = matcher.cursor(matched[query.Limit-1].key)
+		matched = matched[:query.Limit]
+	}
+
+	for _, k := range matched {
+		reading, err := d.readingsRepo.Get(ctx, k.key)
+		if err != nil {
+			// readings deleted since their keys were listed
+			if d.readingsRepo.ErrKeyNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		page.Readings = append(page.Readings, kv.Entry[*entities.Reading]{Key: k.key, Value: reading})
+	}
+
+	return page, nil
+}
@@ -0,0 +1,131 @@
+package domain
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kloudlite/kloudmeter/internal/domain/entities"
+)
+
+func TestReadingsKeyMatcher(t *testing.T) {
+	meter := &entities.Meter{
+		Id:          "bytes",
+		EventType:   "api",
+		Aggregation: entities.AggTypeSum,
+		GroupBy:     map[string]string{"region": "$.region", "tier": "$.tier"},
+	}
+
+	daily := ReadingsQuery{
+		Window: entities.WindowDay,
+		From:   time.Date(2026, 10, 10, 12, 0, 0, 0, time.UTC),
+		To:     time.Date(2026, 10, 20, 0, 0, 0, 0, time.UTC),
+	}
+	dailySegments := daily
+	dailySegments.Segment = "*"
+
+	tests := []struct {
+		name    string
+		query   ReadingsQuery
+		pattern string
+		key     string
+		want    bool
+	}{
+		{"lifetime reading", ReadingsQuery{}, "api.sum.bytes.*", "api.sum.bytes.acme", true},
+		{"windowed reading of a lifetime query", ReadingsQuery{}, "api.sum.bytes.*", "api.sum.bytes.acme.2026-10-18", false},
+		{"reading of another meter", ReadingsQuery{}, "api.sum.bytes.*", "api.sum.bytes2.acme", false},
+		{"subject", ReadingsQuery{Subject: "acme"}, "api.sum.bytes.acme", "api.sum.bytes.acme", true},
+
+		{"window in range", daily, "api.sum.bytes.*.*", "api.sum.bytes.acme.2026-10-18", true},
+		{"window containing from", daily, "api.sum.bytes.*.*", "api.sum.bytes.acme.2026-10-10", true},
+		{"window before from", daily, "api.sum.bytes.*.*", "api.sum.bytes.acme.2026-10-09", false},
+		{"window after to", daily, "api.sum.bytes.*.*", "api.sum.bytes.acme.2026-10-21", false},
+		{"bucket of another window", daily, "api.sum.bytes.*.*", "api.sum.bytes.acme.2026-10", false},
+		{"lifetime reading of a windowed query", daily, "api.sum.bytes.*.*", "api.sum.bytes.acme", false},
+		{"segment of a subject query", daily, "api.sum.bytes.*.*", "api.sum.bytes.acme.region=us-east.tier=pro.2026-10-18", false},
+
+		{"segment", dailySegments, "api.sum.bytes.*.*.*.*", "api.sum.bytes.acme.region=us-east.tier=pro.2026-10-18", true},
+		{"subject reading of a segment query", dailySegments, "api.sum.bytes.*.*.*.*", "api.sum.bytes.acme.2026-10-18", false},
+		{
+			"dimension",
+			ReadingsQuery{Dimensions: map[string]string{"region": "us.east"}},
+			"api.sum.bytes.*.region=us_2eeast.*",
+			"api.sum.bytes.acme.region=us_2eeast.tier=pro",
+			true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := newReadingsKeyMatcher(meter, tt.query)
+			if err != nil {
+				t.Fatalf("newReadingsKeyMatcher() error = %v", err)
+			}
+			if m.pattern != tt.pattern {
+				t.Errorf("pattern = %q, want %q", m.pattern, tt.pattern)
+			}
+			if _, got := m.match(tt.key); got != tt.want {
+				t.Errorf("match(%q) = %v, want %v", tt.key, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewReadingsKeyMatcherErrors(t *testing.T) {
+	dimensional := &entities.Meter{Id: "bytes", EventType: "api", Aggregation: entities.AggTypeSum, GroupBy: map[string]string{"region": "$.region"}}
+	plain := &entities.Meter{Id: "bytes", EventType: "api", Aggregation: entities.AggTypeSum}
+
+	tests := []struct {
+		name  string
+		meter *entities.Meter
+		query ReadingsQuery
+	}{
+		{"unknown dimension", dimensional, ReadingsQuery{Dimensions: map[string]string{"tier": "pro"}}},
+		{"segments of a meter without dimensions", plain, ReadingsQuery{Segment: "*"}},
+		{"unknown window", dimensional, ReadingsQuery{Window: "week"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := newReadingsKeyMatcher(tt.meter, tt.query); err == nil {
+				t.Errorf("newReadingsKeyMatcher() = nil error, want one")
+			}
+		})
+	}
+}
+
+func TestReadingsKeyMatcherCursor(t *testing.T) {
+	meter := &entities.Meter{Id: "bytes", EventType: "api", Aggregation: entities.AggTypeSum}
+
+	m, err := newReadingsKeyMatcher(meter, ReadingsQuery{Window: entities.WindowDay})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := newReadingsKeyMatcher(meter, ReadingsQuery{Subject: "acme", Window: entities.WindowDay})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		cursor  string
+		want    string
+		wantErr bool
+	}{
+		{"own cursor", m.cursor("api.sum.bytes.acme.2026-10-18"), "api.sum.bytes.acme.2026-10-18", false},
+		{"cursor of another query", other.cursor("api.sum.bytes.acme.2026-10-18"), "", true},
+		{"cursor of a key the query does not match", m.cursor("api.sum.bytes.acme"), "", true},
+		{"garbage", "not a cursor!", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := m.after(tt.cursor)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("after() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got.key != tt.want {
+				t.Errorf("after() = %q, want %q", got.key, tt.want)
+			}
+		})
+	}
+}
@@ -4,117 +4,298 @@ import (
 	"context"
 	"fmt"
 	"reflect"
+	"time"
 
 	"github.com/PaesslerAG/jsonpath"
 	"github.com/kloudlite/kloudmeter/internal/domain/entities"
+	"github.com/kloudlite/kloudmeter/pkg/errors"
+	"github.com/kloudlite/kloudmeter/pkg/functions"
 	"github.com/kloudlite/kloudmeter/pkg/kv"
 	"github.com/kloudlite/kloudmeter/pkg/messaging/types"
-	"github.com/nats-io/nats.go/jetstream"
 )
 
 func (d *Impl) ListReadings(ctx context.Context, pattern string) ([]kv.Entry[*entities.Reading], error) {
 	return d.readingsRepo.Entries(ctx, pattern)
 }
 
+// maxQueryWindows caps the number of window buckets a single WindowQuery can span
+const maxQueryWindows = 1000
+
+func (d *Impl) ListWindowReadings(ctx context.Context, query WindowQuery) ([]kv.Entry[*entities.Reading], error) {
+	if err := query.Window.IsValid(); err != nil {
+		return nil, err
+	}
+
+	if query.MeterKey == "" || query.Subject == "" {
+		return nil, errors.New("meter and subject are required")
+	}
+
+	if query.To.Before(query.From) {
+		return nil, errors.New("from must not be after to")
+	}
+
+	entries := make([]kv.Entry[*entities.Reading], 0)
+	for t, i := query.Window.Start(query.From), 0; !t.After(query.To); t, i = query.Window.Next(t), i+1 {
+		if i >= maxQueryWindows {
+			return nil, errors.Newf("time range spans more than %d windows", maxQueryWindows)
+		}
+
+		key := ReadingKey(query.MeterKey, query.Subject, query.Segment, query.Window, t)
+		reading, err := d.readingsRepo.Get(ctx, key)
+		if err != nil {
+			if d.readingsRepo.ErrKeyNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+
+		entries = append(entries, kv.Entry[*entities.Reading]{Key: key, Value: reading})
+	}
+
+	return entries, nil
+}
+
 type upsertValues struct {
 	meter         *entities.Meter
 	event         *entities.Event
+	subject       string
 	segment       string
+	dimensions    map[string]string
 	key           string
 	valueProperty string
+	window        entities.WindowSize
+	eventTime     time.Time
+
+	// amount is the quantity-seconds to add to duration readings, and spanId the id of the accrual span it belongs to
+	amount float64
+	spanId string
+}
+
+// appliedId is the id that readings dedupe the values by, the event id, or the span id of duration accruals
+func (v upsertValues) appliedId() string {
+	if v.event != nil {
+		return v.event.Id
+	}
+	return v.spanId
 }
 
+// maxUpsertAttempts bounds the compare-and-swap retries of a reading, that is concurrently updated by other consumers
+const maxUpsertAttempts = 10
+
+// upsertReadings applies the values to the reading at values.key with compare-and-swap on its KV revision,
+// retrying on the latest revision whenever another consumer (or replica) updated the reading in between.
+// Readings of closed periods are never updated, the values are recorded as an adjustment instead. Besides the
+// cached closed periods, readings frozen by ClosePeriod are diverted, and creating a reading checks its period in KV,
+// so that values never land in a period's reading after it was copied into its snapshot
 func (d *Impl) upsertReadings(ctx context.Context, values upsertValues) error {
-	reading, err := d.readingsRepo.Get(ctx, values.key)
-	if err != nil && err != jetstream.ErrKeyNotFound {
-		return err
+	periodKey := ""
+	if values.window != "" {
+		periodKey = entities.PeriodKey(values.meter.Key(), values.window, values.eventTime)
+
+		period, err := d.cachedClosedPeriod(ctx, periodKey)
+		if err != nil {
+			return err
+		}
+
+		if period != nil {
+			return d.recordAdjustment(ctx, period, values)
+		}
 	}
 
-	if err == jetstream.ErrKeyNotFound {
-		return d.createReading(ctx, values)
+	for attempt := 1; ; attempt++ {
+		reading, revision, err := d.readingsRepo.GetWithRevision(ctx, values.key)
+		if err != nil && !d.readingsRepo.ErrKeyNotFound(err) {
+			return err
+		}
+
+		switch {
+		case d.readingsRepo.ErrKeyNotFound(err):
+			if periodKey != "" {
+				period, err := d.lookupClosedPeriod(ctx, periodKey)
+				if err != nil {
+					return err
+				}
+				if period != nil {
+					return d.recordAdjustment(ctx, period, values)
+				}
+			}
+			err = d.createReading(ctx, values)
+
+		case reading.Closed:
+			period, err := d.lookupClosedPeriod(ctx, periodKey)
+			if err != nil {
+				return err
+			}
+			if period == nil {
+				return errors.Newf("reading (%s) is closed, but its period (%s) does not exist", values.key, periodKey)
+			}
+			return d.recordAdjustment(ctx, period, values)
+
+		default:
+			err = d.updateReading(ctx, reading, revision, values)
+		}
+
+		if err == nil || !d.readingsRepo.ErrRevisionMismatch(err) {
+			return err
+		}
+
+		if attempt >= maxUpsertAttempts {
+			return errors.NewEf(err, "reading (%s) kept changing concurrently, gave up after %d attempts", values.key, attempt)
+		}
+
+		d.logger.Debugf("reading (%s) was updated concurrently, retrying", values.key)
 	}
+}
 
-	return d.updateReading(ctx, reading, values)
+// readingWindows returns the windows a meter's readings are bucketed into, an empty window stands for the lifetime reading
+func readingWindows(meter *entities.Meter) []entities.WindowSize {
+	if len(meter.Windows) == 0 {
+		return []entities.WindowSize{""}
+	}
+	return meter.Windows
 }
 
-func (d *Impl) updateReadings(ctx context.Context, meter *entities.Meter, event *entities.Event) error {
+func (d *Impl) produceEventError(ctx context.Context, event *entities.Event) {
+	b, err := event.ToJson()
+	if err != nil {
+		d.logger.Errorf(err, "faild to marshal json")
+		return
+	}
 
-	key := fmt.Sprintf("%s.%s", meter.Key(), event.Subject)
-	if err := d.upsertReadings(ctx, upsertValues{
-		meter:         meter,
-		event:         event,
-		segment:       "",
-		key:           key,
-		valueProperty: meter.ValueProperty,
+	if err := d.meterProducer.Produce(ctx, types.ProduceMsg{
+		Subject: fmt.Sprintf("meters.event-errors.%s", event.Key()),
+		Payload: b,
+		MsgID:   &event.Id,
 	}); err != nil {
-		d.logger.Errorf(err, "failed to updateReadings")
+		d.logger.Errorf(err, "failed to add produce message to dead letter queue")
+		return
+	}
+	deadLetterEvents.WithLabelValues(event.EventType).Inc()
+}
 
-		b, err := event.ToJson()
-		if err != nil {
-			d.logger.Errorf(err, "faild to marshal json")
-		} else {
+func (d *Impl) updateReadings(ctx context.Context, meter *entities.Meter, event *entities.Event) error {
+	start := time.Now()
+	defer func() {
+		updateReadingsDuration.WithLabelValues(meter.Key()).Observe(time.Since(start).Seconds())
+	}()
 
-			if err := d.meterProducer.Produce(ctx, types.ProduceMsg{
-				Subject: fmt.Sprintf("meters.event-errors.%s", event.Key()),
-				Payload: b,
-				MsgID:   &event.Id,
-			}); err != nil {
-				d.logger.Errorf(err, "failed to add produce message to dead letter queue")
-			}
-		}
+	eventTime, err := event.Timestamp()
+	if err != nil {
+		d.logger.Errorf(err, "failed to updateReadings")
+		d.produceEventError(ctx, event)
+		return nil
+	}
 
+	if meter.Aggregation == entities.AggTypeDuration {
+		if err := d.updateDurations(ctx, meter, event, eventTime); err != nil {
+			d.logger.Errorf(err, "failed to updateReadings")
+			d.produceEventError(ctx, event)
+		}
+		return nil
 	}
 
-	for k, segment := range meter.GroupBy {
-		key := fmt.Sprintf("%s.%s.%s", meter.Key(), event.Subject, k)
+	dimensions := extractDimensions(meter, event)
+	segment := entities.DimensionsKey(dimensions)
+
+	for _, window := range readingWindows(meter) {
 		if err := d.upsertReadings(ctx, upsertValues{
 			meter:         meter,
 			event:         event,
-			segment:       k,
-			key:           key,
-			valueProperty: segment,
+			subject:       event.Subject,
+			segment:       "",
+			key:           ReadingKey(meter.Key(), event.Subject, "", window, eventTime),
+			valueProperty: meter.ValueProperty,
+			window:        window,
+			eventTime:     eventTime,
 		}); err != nil {
 			d.logger.Errorf(err, "failed to updateReadings")
+			d.produceEventError(ctx, event)
+		}
 
-			b, err := event.ToJson()
-			if err != nil {
-				d.logger.Errorf(err, "faild to marshal json")
-				continue
-			}
-
-			if err := d.meterProducer.Produce(ctx, types.ProduceMsg{
-				Subject: fmt.Sprintf("meters.event-errors.%s", event.Key()),
-				Payload: b,
-				MsgID:   &event.Id,
-			}); err != nil {
-				d.logger.Errorf(err, "failed to add produce message to dead letter queue")
-			}
+		if len(dimensions) == 0 {
+			continue
+		}
 
+		if err := d.upsertReadings(ctx, upsertValues{
+			meter:         meter,
+			event:         event,
+			subject:       event.Subject,
+			segment:       segment,
+			dimensions:    dimensions,
+			key:           ReadingKey(meter.Key(), event.Subject, segment, window, eventTime),
+			valueProperty: meter.ValueProperty,
+			window:        window,
+			eventTime:     eventTime,
+		}); err != nil {
+			d.logger.Errorf(err, "failed to updateReadings")
+			d.produceEventError(ctx, event)
 		}
 	}
 
 	return nil
 }
 
-func (d *Impl) updateReading(ctx context.Context, reading *entities.Reading, values upsertValues) error {
+// extractDimensions reads the meter's group by dimensions from event data, dimensions missing on the event get an empty value
+func extractDimensions(meter *entities.Meter, event *entities.Event) map[string]string {
+	if len(meter.GroupBy) == 0 {
+		return nil
+	}
+
+	dimensions := make(map[string]string, len(meter.GroupBy))
+	for name, path := range meter.GroupBy {
+		v, err := jsonpath.Get(path, event.Data)
+		if err != nil || v == nil {
+			dimensions[name] = ""
+			continue
+		}
+		dimensions[name] = fmt.Sprint(v)
+	}
+	return dimensions
+}
+
+func (d *Impl) updateReading(ctx context.Context, reading *entities.Reading, revision uint64, values upsertValues) error {
+	if id := values.appliedId(); id != "" && reading.AppliedEventHashes.With(reading.AppliedEvents).Contains(id) {
+		d.logger.Infof("event (%s) has already been applied to reading (%s), skipping", id, values.key)
+		reading.Duplicates = reading.Duplicates + 1
+		_, err := d.readingsRepo.Update(ctx, values.key, reading, revision)
+		return err
+	}
+
 	value := &entities.Reading{
-		Event:   reading.Event,
-		MeterId: reading.MeterId,
-		Subject: reading.Subject,
-		Segment: reading.Segment,
-		Type:    reading.Type,
-		Sum:     reading.Sum,
-		Avg:     reading.Avg,
-		Max:     reading.Max,
-		Min:     reading.Min,
-		Count:   reading.Count,
-		Unique:  reading.Unique,
+		Event:        reading.Event,
+		MeterId:      reading.MeterId,
+		MeterVersion: values.meter.Version,
+		Subject:      reading.Subject,
+		Segment:      reading.Segment,
+		Dimensions:   reading.Dimensions,
+		Window:       reading.Window,
+		WindowStart:  reading.WindowStart,
+		WindowEnd:    reading.WindowEnd,
+		Type:         reading.Type,
+		Duplicates:   reading.Duplicates,
+		Sum:          reading.Sum,
+		Avg:          reading.Avg,
+		Max:          reading.Max,
+		Min:          reading.Min,
+		Range:        reading.Range,
+		Count:        reading.Count,
+		Func:         reading.Func,
+		Value:        reading.Value,
+		ObservedAt:   reading.ObservedAt,
+		Unique:       reading.Unique,
+		Sketch:       reading.Sketch,
+		Quantiles:    reading.Quantiles,
+
+		Distinct:       reading.Distinct,
+		Approximate:    reading.Approximate,
+		DistinctValues: reading.DistinctValues,
+		HLL:            reading.HLL,
 	}
 
 	switch values.meter.Aggregation {
 	case entities.AggTypeCount:
 		value.Count = reading.Count + 1
-	case entities.AggTypeSum, entities.AggTypeAvg, entities.AggTypeMax, entities.AggTypeMin:
+	case entities.AggTypeSum, entities.AggTypeAvg, entities.AggTypeMax, entities.AggTypeMin, entities.AggTypeRange:
 		val, err := dataOnPath[float64](values.event.Data, values.valueProperty)
 		if err != nil {
 			return err
@@ -135,9 +316,70 @@ func (d *Impl) updateReading(ctx context.Context, reading *entities.Reading, val
 			if value.Min > *val {
 				value.Min = *val
 			}
+
+		case entities.AggTypeRange:
+			if value.Max < *val {
+				value.Max = *val
+			}
+			if value.Min > *val {
+				value.Min = *val
+			}
+			value.Range = value.Max - value.Min
+		}
+
+		value.Count = reading.Count + 1
+
+	case entities.AggTypeFunc:
+		val, err := d.evalFunc(values.meter, reading, values.event, values.eventTime)
+		if err != nil {
+			return err
+		}
+
+		value.Func = values.meter.Func
+		value.Value = val
+		value.Count = reading.Count + 1
+
+	case entities.AggTypePercentile:
+		val, err := dataOnPath[float64](values.event.Data, values.valueProperty)
+		if err != nil {
+			return err
+		}
+
+		if err := addToSketch(value, values.meter, *val); err != nil {
+			return err
+		}
+		value.Count = reading.Count + 1
+
+	case entities.AggTypeCardinality:
+		val, err := dataOnPath[any](values.event.Data, values.valueProperty)
+		if err != nil {
+			return err
+		}
+
+		if err := addDistinct(value, values.meter, fmt.Sprint(*val)); err != nil {
+			return err
+		}
+		value.Count = reading.Count + 1
+
+	case entities.AggTypeDuration:
+		value.Sum = reading.Sum + values.amount
+		value.Count = reading.Count + 1
+
+	case entities.AggTypeLatest, entities.AggTypeFirst:
+		val, err := dataOnPath[float64](values.event.Data, values.valueProperty)
+		if err != nil {
+			return err
 		}
 
+		// compares event times, so that redelivered or out of order events do not replace a newer (or for first, an older) value
+		if reading.ObservedAt == nil ||
+			(values.meter.Aggregation == entities.AggTypeLatest && values.eventTime.After(*reading.ObservedAt)) ||
+			(values.meter.Aggregation == entities.AggTypeFirst && values.eventTime.Before(*reading.ObservedAt)) {
+			value.Value = *val
+			value.ObservedAt = functions.New(values.eventTime)
+		}
 		value.Count = reading.Count + 1
+
 	case entities.AggTypeUnique:
 		val, err := dataOnPath[string](values.event.Data, values.valueProperty)
 		if err != nil {
@@ -160,24 +402,41 @@ func (d *Impl) updateReading(ctx context.Context, reading *entities.Reading, val
 		return fmt.Errorf("unknown aggregation type: %s", values.meter.Aggregation)
 	}
 
-	return d.readingsRepo.Set(ctx, values.key, value)
+	if id := values.appliedId(); id != "" {
+		value.AppliedEventHashes = reading.AppliedEventHashes.With(reading.AppliedEvents).Add(id, d.env.DedupeHistorySize)
+	}
+
+	if _, err := d.readingsRepo.Update(ctx, values.key, value, revision); err != nil {
+		return err
+	}
+
+	d.evaluateAlerts(ctx, values.meter, values.key, value)
+	return nil
 }
 
 func (d *Impl) createReading(ctx context.Context, values upsertValues) error {
 
 	value := &entities.Reading{
-		Event:   values.meter.EventType,
-		MeterId: values.meter.Id,
-		Segment: values.segment,
-		Subject: values.event.Subject,
-		Type:    values.meter.Aggregation,
-		Count:   1,
+		Event:        values.meter.EventType,
+		MeterId:      values.meter.Id,
+		MeterVersion: values.meter.Version,
+		Segment:      values.segment,
+		Dimensions:   values.dimensions,
+		Subject:      values.subject,
+		Type:         values.meter.Aggregation,
+		Count:        1,
+	}
+
+	if values.window != "" {
+		value.Window = values.window
+		value.WindowStart = functions.New(values.window.Start(values.eventTime))
+		value.WindowEnd = functions.New(values.window.Next(values.eventTime))
 	}
 
 	switch values.meter.Aggregation {
 
 	case entities.AggTypeCount:
-	case entities.AggTypeSum, entities.AggTypeAvg, entities.AggTypeMax, entities.AggTypeMin:
+	case entities.AggTypeSum, entities.AggTypeAvg, entities.AggTypeMax, entities.AggTypeMin, entities.AggTypeRange:
 		val, err := dataOnPath[float64](values.event.Data, values.valueProperty)
 		if err != nil {
 			return err
@@ -195,8 +454,54 @@ func (d *Impl) createReading(ctx context.Context, values upsertValues) error {
 
 		case entities.AggTypeMin:
 			value.Min = *val
+
+		case entities.AggTypeRange:
+			value.Min = *val
+			value.Max = *val
+			value.Range = 0
+		}
+
+	case entities.AggTypeFunc:
+		val, err := d.evalFunc(values.meter, nil, values.event, values.eventTime)
+		if err != nil {
+			return err
 		}
 
+		value.Func = values.meter.Func
+		value.Value = val
+
+	case entities.AggTypePercentile:
+		val, err := dataOnPath[float64](values.event.Data, values.valueProperty)
+		if err != nil {
+			return err
+		}
+
+		if err := addToSketch(value, values.meter, *val); err != nil {
+			return err
+		}
+
+	case entities.AggTypeCardinality:
+		val, err := dataOnPath[any](values.event.Data, values.valueProperty)
+		if err != nil {
+			return err
+		}
+
+		if err := addDistinct(value, values.meter, fmt.Sprint(*val)); err != nil {
+			return err
+		}
+
+	case entities.AggTypeDuration:
+		value.Sum = values.amount
+
+	case entities.AggTypeLatest, entities.AggTypeFirst:
+		val, err := dataOnPath[float64](values.event.Data, values.valueProperty)
+		if err != nil {
+			return err
+		}
+
+		value.Value = *val
+		value.ObservedAt = functions.New(values.eventTime)
+
 	case entities.AggTypeUnique:
 		val, err := dataOnPath[string](values.event.Data, values.valueProperty)
 		if err != nil {
@@ -217,7 +522,16 @@ func (d *Impl) createReading(ctx context.Context, values upsertValues) error {
 		return fmt.Errorf("unknown aggregation type: %s", values.meter.Aggregation)
 	}
 
-	return d.readingsRepo.Set(ctx, values.key, value)
+	if id := values.appliedId(); id != "" {
+		value.AppliedEventHashes = value.AppliedEventHashes.Add(id, d.env.DedupeHistorySize)
+	}
+
+	if _, err := d.readingsRepo.Create(ctx, values.key, value); err != nil {
+		return err
+	}
+
+	d.evaluateAlerts(ctx, values.meter, values.key, value)
+	return nil
 }
 
 func dataOnPath[T any](data map[string]any, jsPath string) (*T, error) {
@@ -227,6 +541,10 @@ func dataOnPath[T any](data map[string]any, jsPath string) (*T, error) {
 		return nil, err
 	}
 
+	if i == nil {
+		return nil, fmt.Errorf("no value found at path %s", jsPath)
+	}
+
 	// Check if the type of i matches the type of T
 	value := reflect.ValueOf(i)
 	targetType := reflect.TypeOf((*T)(nil)).Elem()
@@ -0,0 +1,120 @@
+package domain
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/kloudlite/kloudmeter/internal/domain/entities"
+	"github.com/kloudlite/kloudmeter/pkg/errors"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+func (d *Impl) RollupReadings(ctx context.Context, query RollupQuery) ([]*entities.Reading, error) {
+	meter, err := d.GetMeter(ctx, query.MeterKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := meter.Mergeable(); err != nil {
+		return nil, err
+	}
+
+	if query.Window != "" {
+		if err := query.Window.IsValid(); err != nil {
+			return nil, err
+		}
+	}
+
+	for name := range query.Dimensions {
+		if _, ok := meter.GroupBy[name]; !ok {
+			return nil, errors.Newf("meter has no dimension %q", name)
+		}
+	}
+
+	for _, name := range query.GroupBy {
+		if _, ok := meter.GroupBy[name]; !ok {
+			return nil, errors.Newf("meter has no dimension %q", name)
+		}
+	}
+
+	// readings of a meter's dimensions are keyed as <meter-key>.<subject>.<dimension=value>...[.<window-bucket>],
+	// so narrowing the watch down to the filtered dimensions avoids reading every reading of the meter
+	tokens := []string{meter.Key(), "*"}
+	if query.Subject != "" {
+		tokens[1] = query.Subject
+	}
+
+	for _, name := range meter.DimensionNames() {
+		if v, ok := query.Dimensions[name]; ok {
+			tokens = append(tokens, entities.DimensionToken(name, v))
+			continue
+		}
+		tokens = append(tokens, "*")
+	}
+
+	if query.Window != "" {
+		tokens = append(tokens, "*")
+	}
+
+	entries, err := d.readingsRepo.Entries(ctx, strings.Join(tokens, "."))
+	if err != nil {
+		if errors.Is(err, jetstream.ErrNoKeysFound) {
+			return []*entities.Reading{}, nil
+		}
+		return nil, err
+	}
+
+	groups := map[string][]*entities.Reading{}
+	for _, entry := range entries {
+		r := entry.Value
+		if r.Dimensions == nil || r.Window != query.Window {
+			continue
+		}
+
+		if query.Window != "" && r.WindowStart != nil {
+			if !query.From.IsZero() && r.WindowStart.Before(query.Window.Start(query.From)) {
+				continue
+			}
+			if !query.To.IsZero() && r.WindowStart.After(query.To) {
+				continue
+			}
+		}
+
+		matches := true
+		for name, v := range query.Dimensions {
+			if r.Dimensions[name] != v {
+				matches = false
+				break
+			}
+		}
+
+		if !matches {
+			continue
+		}
+
+		groupKey := entities.DimensionsKey(withDimensions(r.Dimensions, query.GroupBy))
+		groups[groupKey] = append(groups[groupKey], r)
+	}
+
+	keys := make([]string, 0, len(groups))
+	for k := range groups {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	result := make([]*entities.Reading, 0, len(keys))
+	for _, k := range keys {
+		merged, err := mergeReadings(meter, groups[k])
+		if err != nil {
+			return nil, fmt.Errorf("failed to roll up readings: %w", err)
+		}
+
+		merged.Dimensions = withDimensions(groups[k][0].Dimensions, query.GroupBy)
+		merged.Segment = k
+		result = append(result, merged)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,177 @@
+package domain
+
+import (
+	"context"
+	"time"
+
+	"github.com/kloudlite/kloudmeter/internal/domain/entities"
+	"github.com/kloudlite/kloudmeter/pkg/errors"
+	"github.com/kloudlite/kloudmeter/pkg/functions"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// seriesStep is either a calendar window (hour, day or month), or a fixed duration aligned to UTC midnight of year 1,
+// e.g. 6h or 168h for weeks starting on mondays
+type seriesStep struct {
+	window   entities.WindowSize
+	duration time.Duration
+}
+
+func parseSeriesStep(step string) (seriesStep, error) {
+	if w := entities.WindowSize(step); w.IsValid() == nil {
+		return seriesStep{window: w}, nil
+	}
+
+	d, err := time.ParseDuration(step)
+	if err != nil || d < time.Hour || d%time.Hour != 0 {
+		return seriesStep{}, errors.Newf("step must be hour, day, month or a duration in whole hours, e.g. 6h")
+	}
+	return seriesStep{duration: d}, nil
+}
+
+func (s seriesStep) start(t time.Time) time.Time {
+	if s.window != "" {
+		return s.window.Start(t)
+	}
+	return t.UTC().Truncate(s.duration)
+}
+
+func (s seriesStep) next(t time.Time) time.Time {
+	if s.window != "" {
+		return s.window.Next(t)
+	}
+	return s.start(t).Add(s.duration)
+}
+
+// fits reports whether every step is made of whole windows of w
+func (s seriesStep) fits(w entities.WindowSize) bool {
+	switch s.window {
+	case entities.WindowMonth:
+		return true
+	case entities.WindowDay:
+		return w != entities.WindowMonth
+	case entities.WindowHour:
+		return w == entities.WindowHour
+	}
+
+	switch w {
+	case entities.WindowHour:
+		return true
+	case entities.WindowDay:
+		return s.duration%(24*time.Hour) == 0
+	}
+	return false
+}
+
+// sourceWindow returns the largest window of the meter that fits the step, so that the fewest readings are merged
+func (s seriesStep) sourceWindow(meter *entities.Meter) (entities.WindowSize, bool) {
+	for _, w := range []entities.WindowSize{entities.WindowMonth, entities.WindowDay, entities.WindowHour} {
+		if meter.HasWindow(w) && s.fits(w) {
+			return w, true
+		}
+	}
+	return "", false
+}
+
+// QuerySeries re-aggregates the meter's window readings into points of the query's step, following the meter's
+// aggregation semantics (see mergeReadings). Without a subject, the readings of every subject are merged
+func (d *Impl) QuerySeries(ctx context.Context, query SeriesQuery) (*entities.Series, error) {
+	step, err := parseSeriesStep(query.Step)
+	if err != nil {
+		return nil, err
+	}
+
+	if query.To.Before(query.From) {
+		return nil, errors.New("from must not be after to")
+	}
+
+	meter, err := d.meterRepo.Get(ctx, query.MeterKey)
+	if err != nil {
+		if d.meterRepo.ErrKeyNotFound(err) {
+			return nil, MeterNotFoundError
+		}
+		return nil, err
+	}
+
+	if err := meter.Mergeable(); err != nil {
+		return nil, err
+	}
+
+	source, ok := step.sourceWindow(meter)
+	if !ok {
+		return nil, errors.Newf("meter (%s) keeps no window readings that fit step %s", meter.Key(), query.Step)
+	}
+
+	series := &entities.Series{
+		Meter:    meter.Key(),
+		Subject:  query.Subject,
+		Step:     query.Step,
+		Source:   source,
+		Quantity: query.Quantity,
+		Points:   []entities.SeriesPoint{},
+	}
+
+	var starts []time.Time
+	for t := step.start(query.From); !t.After(query.To); t = step.next(t) {
+		if len(starts) >= maxQueryWindows {
+			return nil, errors.Newf("time range spans more than %d steps", maxQueryWindows)
+		}
+		starts = append(starts, t)
+	}
+
+	// readings of source windows are listed from the start of the first step, until the end of the last one
+	matcher, err := newReadingsKeyMatcher(meter, ReadingsQuery{
+		Subject: query.Subject,
+		Window:  source,
+		From:    starts[0],
+		To:      step.next(starts[len(starts)-1]).Add(-time.Nanosecond),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := d.readingsRepo.Keys(ctx, matcher.pattern)
+	if err != nil && !errors.Is(err, jetstream.ErrNoKeysFound) {
+		return nil, err
+	}
+
+	groups := make(map[time.Time][]*entities.Reading, len(starts))
+	for _, key := range keys {
+		k, ok := matcher.match(key)
+		if !ok {
+			continue
+		}
+
+		reading, err := d.readingsRepo.Get(ctx, key)
+		if err != nil {
+			if d.readingsRepo.ErrKeyNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+
+		start := step.start(k.windowStart)
+		groups[start] = append(groups[start], reading)
+	}
+
+	for _, start := range starts {
+		point := entities.SeriesPoint{Start: start}
+
+		if readings := groups[start]; len(readings) > 0 {
+			merged, err := mergeReadings(meter, readings)
+			if err != nil {
+				return nil, err
+			}
+
+			value, err := entities.ReadingQuantity(meter, merged, query.Quantity)
+			if err != nil {
+				return nil, err
+			}
+			point.Value = functions.New(value)
+		}
+
+		series.Points = append(series.Points, point)
+	}
+
+	return series, nil
+}
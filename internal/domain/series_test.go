@@ -0,0 +1,129 @@
+package domain
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kloudlite/kloudmeter/internal/domain/entities"
+)
+
+func TestParseSeriesStep(t *testing.T) {
+	tests := []struct {
+		step    string
+		want    seriesStep
+		wantErr bool
+	}{
+		{"hour", seriesStep{window: entities.WindowHour}, false},
+		{"day", seriesStep{window: entities.WindowDay}, false},
+		{"month", seriesStep{window: entities.WindowMonth}, false},
+		{"6h", seriesStep{duration: 6 * time.Hour}, false},
+		{"168h", seriesStep{duration: 168 * time.Hour}, false},
+		{"", seriesStep{}, true},
+		{"week", seriesStep{}, true},
+		{"30m", seriesStep{}, true},
+		{"90m", seriesStep{}, true},
+		{"-6h", seriesStep{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.step, func(t *testing.T) {
+			got, err := parseSeriesStep(tt.step)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseSeriesStep() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseSeriesStep() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSeriesStepFits(t *testing.T) {
+	tests := []struct {
+		step   string
+		window entities.WindowSize
+		want   bool
+	}{
+		{"hour", entities.WindowHour, true},
+		{"hour", entities.WindowDay, false},
+		{"day", entities.WindowHour, true},
+		{"day", entities.WindowDay, true},
+		{"day", entities.WindowMonth, false},
+		{"month", entities.WindowDay, true},
+		{"month", entities.WindowMonth, true},
+		{"6h", entities.WindowHour, true},
+		{"6h", entities.WindowDay, false},
+		{"48h", entities.WindowDay, true},
+		{"36h", entities.WindowDay, false},
+		{"720h", entities.WindowMonth, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.step+"/"+string(tt.window), func(t *testing.T) {
+			step, err := parseSeriesStep(tt.step)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := step.fits(tt.window); got != tt.want {
+				t.Errorf("fits() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSeriesStepSourceWindow(t *testing.T) {
+	tests := []struct {
+		name    string
+		step    string
+		windows []entities.WindowSize
+		want    entities.WindowSize
+		ok      bool
+	}{
+		{"largest window that fits", "month", []entities.WindowSize{entities.WindowHour, entities.WindowDay, entities.WindowMonth}, entities.WindowMonth, true},
+		{"day steps of hourly readings", "day", []entities.WindowSize{entities.WindowHour, entities.WindowMonth}, entities.WindowHour, true},
+		{"hour steps of daily readings", "hour", []entities.WindowSize{entities.WindowDay}, "", false},
+		{"lifetime readings", "day", nil, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, err := parseSeriesStep(tt.step)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, ok := step.sourceWindow(&entities.Meter{Windows: tt.windows})
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("sourceWindow() = %q, %v, want %q, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestSeriesStepBounds(t *testing.T) {
+	tests := []struct {
+		step  string
+		t     time.Time
+		start time.Time
+		next  time.Time
+	}{
+		{"day", time.Date(2026, 10, 18, 14, 0, 0, 0, time.UTC), time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC), time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)},
+		{"6h", time.Date(2026, 10, 18, 14, 30, 0, 0, time.UTC), time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC), time.Date(2026, 10, 18, 18, 0, 0, 0, time.UTC)},
+		// weeks start on mondays, 2026-10-18 is a sunday
+		{"168h", time.Date(2026, 10, 18, 14, 0, 0, 0, time.UTC), time.Date(2026, 10, 12, 0, 0, 0, 0, time.UTC), time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.step, func(t *testing.T) {
+			step, err := parseSeriesStep(tt.step)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := step.start(tt.t); !got.Equal(tt.start) {
+				t.Errorf("start() = %v, want %v", got, tt.start)
+			}
+			if got := step.next(tt.t); !got.Equal(tt.next) {
+				t.Errorf("next() = %v, want %v", got, tt.next)
+			}
+		})
+	}
+}
@@ -1,6 +1,8 @@
 package env
 
 import (
+	"time"
+
 	"github.com/codingconcepts/env"
 	"github.com/kloudlite/kloudmeter/pkg/errors"
 )
@@ -9,7 +11,36 @@ type Env struct {
 	NatsURL         string `env:"NATS_URL" required:"true" default:"nats://localhost:4222"`
 	MeterNatsStream string `env:"METER_NATS_STREAM" required:"true" default:"meters"`
 	HttpServerPort  string `env:"HTTP_SERVER_PORT" required:"true" default:"8080"`
-	IsDev           bool
+
+	// AdminServerPort serves the operational metrics of kloudmeter itself at /metrics, apart from the API
+	AdminServerPort string `env:"ADMIN_SERVER_PORT" default:"9090"`
+
+	// DurationFlushInterval is how often open intervals of duration meters are accounted into readings
+	DurationFlushInterval time.Duration `env:"DURATION_FLUSH_INTERVAL" default:"1m"`
+
+	// DedupeHistorySize is the number of latest event ids remembered per reading, to skip redelivered events
+	DedupeHistorySize int `env:"DEDUPE_HISTORY_SIZE" default:"1000"`
+
+	// EventsBatchMaxSize is the maximum number of events accepted in a single batch ingestion request
+	EventsBatchMaxSize int `env:"EVENTS_BATCH_MAX_SIZE" default:"10000"`
+
+	// AlertWebhookMaxAttempts is the number of attempts to deliver an alert to its webhook, before it is logged as failed
+	AlertWebhookMaxAttempts int `env:"ALERT_WEBHOOK_MAX_ATTEMPTS" default:"5"`
+
+	// AlertWebhookTimeout is the timeout of a single webhook delivery attempt
+	AlertWebhookTimeout time.Duration `env:"ALERT_WEBHOOK_TIMEOUT" default:"10s"`
+
+	// AlertStateTTL is how long fired alerts are remembered, it has to outlast the longest window (month), so that
+	// alerts do not fire again for the same window. Alerts of lifetime readings fire again at most once per TTL
+	AlertStateTTL time.Duration `env:"ALERT_STATE_TTL" default:"840h"`
+
+	// OtlpSubjectAttribute is the resource attribute whose value is the subject of events received over OTLP
+	OtlpSubjectAttribute string `env:"OTLP_SUBJECT_ATTRIBUTE" default:"service.name"`
+
+	// OtlpSeriesTTL is how long the latest point of a cumulative OTLP series is kept, to convert its next point to deltas
+	OtlpSeriesTTL time.Duration `env:"OTLP_SERIES_TTL" default:"24h"`
+
+	IsDev bool
 }
 
 func LoadEnv() (*Env, error) {
@@ -1,11 +1,17 @@
 package framework
 
 import (
+	"context"
+	"net"
+	"net/http"
+
 	"github.com/kloudlite/kloudmeter/internal/app"
 	"github.com/kloudlite/kloudmeter/internal/env"
+	"github.com/kloudlite/kloudmeter/pkg/errors"
 	httpServer "github.com/kloudlite/kloudmeter/pkg/http-server"
 	"github.com/kloudlite/kloudmeter/pkg/logging"
 	"github.com/kloudlite/kloudmeter/pkg/nats"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.uber.org/fx"
 )
 
@@ -40,5 +46,33 @@ var Module = fx.Module(
 		return server.Listen(":" + envVars.HttpServerPort)
 	}),
 
+	// the admin server exposes the default prometheus registry, with the metrics of the pipeline and go runtime
+	fx.Invoke(func(lf fx.Lifecycle, envVars *env.Env, logger logging.Logger) {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", promhttp.Handler())
+		admin := &http.Server{Addr: ":" + envVars.AdminServerPort, Handler: mux}
+
+		lf.Append(fx.Hook{
+			OnStart: func(context.Context) error {
+				l, err := net.Listen("tcp", admin.Addr)
+				if err != nil {
+					return errors.NewE(err)
+				}
+
+				go func() {
+					if err := admin.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+						logger.Errorf(err, "admin server stopped")
+					}
+				}()
+
+				logger.Infof("Admin Server started @ (addr: %q)", admin.Addr)
+				return nil
+			},
+			OnStop: func(ctx context.Context) error {
+				return admin.Shutdown(ctx)
+			},
+		})
+	}),
+
 	app.Module,
 )
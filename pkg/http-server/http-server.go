@@ -20,10 +20,13 @@ import (
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	l "github.com/gofiber/fiber/v2/middleware/logger"
 	"github.com/kloudlite/kloudmeter/pkg/logging"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 type Server interface {
 	SetupGraphqlServer(es graphql.ExecutableSchema, middlewares ...fiber.Handler)
+	SetupMetrics(path string, collectors ...prometheus.Collector) error
 	Listen(addr string) error
 	Close() error
 
@@ -128,3 +131,30 @@ func (s *server) SetupGraphqlServer(es graphql.ExecutableSchema, middlewares ...
 
 	s.All("/query", adaptor.HTTPHandlerFunc(gqlServer.ServeHTTP))
 }
+
+// SetupMetrics serves the collectors at path, in the prometheus text format or OpenMetrics, as negotiated by the scraper.
+// A collector failing to collect a metric does not fail the whole scrape
+func (s *server) SetupMetrics(path string, collectors ...prometheus.Collector) error {
+	registry := prometheus.NewRegistry()
+	for _, c := range collectors {
+		if err := registry.Register(c); err != nil {
+			return errors.NewE(err)
+		}
+	}
+
+	s.Get(path, adaptor.HTTPHandler(promhttp.HandlerFor(registry, promhttp.HandlerOpts{
+		EnableOpenMetrics: true,
+		ErrorHandling:     promhttp.ContinueOnError,
+		ErrorLog:          promLogger{s.Logger},
+	})))
+	return nil
+}
+
+// promLogger logs the errors of metric collection
+type promLogger struct {
+	logger logging.Logger
+}
+
+func (l promLogger) Println(v ...interface{}) {
+	l.logger.Warnf("metrics: %s", fmt.Sprint(v...))
+}
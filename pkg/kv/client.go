@@ -18,10 +18,21 @@ type Repo[T any] interface {
 	Set(c context.Context, key string, value T) error
 	SetWithExpiry(c context.Context, key string, value T, duration time.Duration) error
 	Get(c context.Context, key string) (T, error)
+	// GetWithRevision returns the value along with its revision, to be passed to Update
+	GetWithRevision(c context.Context, key string) (T, uint64, error)
+	// Update sets the value only if the key is still at the given revision
+	Update(c context.Context, key string, value T, revision uint64) (uint64, error)
+	// Create sets the value only if the key does not exist yet
+	Create(c context.Context, key string, value T) (uint64, error)
 	Keys(c context.Context, pattern string) ([]string, error)
 	List(c context.Context, pattern string) ([]T, error)
 	Entries(c context.Context, pattern string) ([]Entry[T], error)
+	// Watch calls onUpdate with every entry matching pattern, first the current ones and then every change,
+	// until c is done. Deleted keys come with deleted set, and onSynced is called once the current entries are through
+	Watch(c context.Context, pattern string, onUpdate func(entry Entry[T], deleted bool), onSynced func()) error
 	ErrKeyNotFound(err error) bool
+	// ErrRevisionMismatch reports whether Update or Create failed, as the key was changed (or created) concurrently
+	ErrRevisionMismatch(err error) bool
 	Drop(c context.Context, key string) error
 }
 
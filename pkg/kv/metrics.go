@@ -0,0 +1,30 @@
+package kv
+
+import (
+	"github.com/kloudlite/kloudmeter/pkg/errors"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	kvOperations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "kloudmeter_kv_operations_total",
+		Help: "Operations on KV buckets, by bucket and operation",
+	}, []string{"bucket", "op"})
+
+	kvErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "kloudmeter_kv_errors_total",
+		Help: "Failed operations on KV buckets, by bucket and operation. Missing keys and revision mismatches are not counted",
+	}, []string{"bucket", "op"})
+)
+
+// observe counts an operation on the bucket, and its error unless it is one that callers handle, e.g. a missing key
+func (r *natsKVRepo[T]) observe(op string, err error) {
+	kvOperations.WithLabelValues(r.keyValue.Bucket(), op).Inc()
+
+	if err == nil || errors.Is(err, jetstream.ErrKeyNotFound) || errors.Is(err, jetstream.ErrKeyExists) {
+		return
+	}
+	kvErrors.WithLabelValues(r.keyValue.Bucket(), op).Inc()
+}
@@ -38,6 +38,7 @@ func (r *natsKVRepo[T]) List(c context.Context, pattern string) ([]T, error) {
 	opts := []jetstream.WatchOpt{jetstream.IgnoreDeletes()}
 
 	watcher, err := r.keyValue.Watch(c, pattern, opts...)
+	r.observe("watch", err)
 	if err != nil {
 		return nil, err
 	}
@@ -73,6 +74,7 @@ func (r *natsKVRepo[T]) Entries(c context.Context, pattern string) ([]Entry[T],
 	opts := []jetstream.WatchOpt{jetstream.IgnoreDeletes()}
 
 	watcher, err := r.keyValue.Watch(c, pattern, opts...)
+	r.observe("watch", err)
 	if err != nil {
 		return nil, err
 	}
@@ -108,10 +110,50 @@ func (r *natsKVRepo[T]) Entries(c context.Context, pattern string) ([]Entry[T],
 	return entries, nil
 }
 
+func (r *natsKVRepo[T]) Watch(c context.Context, pattern string, onUpdate func(entry Entry[T], deleted bool), onSynced func()) error {
+	watcher, err := r.keyValue.Watch(c, pattern)
+	r.observe("watch", err)
+	if err != nil {
+		return errors.NewE(err)
+	}
+	defer watcher.Stop()
+
+	for {
+		select {
+		case <-c.Done():
+			return nil
+		case entry, ok := <-watcher.Updates():
+			if !ok {
+				return nil
+			}
+
+			// a nil entry marks that every current entry has been delivered
+			if entry == nil {
+				if onSynced != nil {
+					onSynced()
+				}
+				continue
+			}
+
+			if entry.Operation() == jetstream.KeyValueDelete || entry.Operation() == jetstream.KeyValuePurge {
+				onUpdate(Entry[T]{Key: entry.Key()}, true)
+				continue
+			}
+
+			var value Value[T]
+			if err := egob.Unmarshal(entry.Value(), &value); err != nil {
+				return errors.NewEf(err, "failed to unmarshal value of key %s", entry.Key())
+			}
+			onUpdate(Entry[T]{Key: entry.Key(), Value: value.Data}, false)
+		}
+	}
+}
+
 func (r *natsKVRepo[T]) Keys(c context.Context, pattern string) ([]string, error) {
 	opts := []jetstream.WatchOpt{jetstream.IgnoreDeletes(), jetstream.MetaOnly()}
 
 	watcher, err := r.keyValue.Watch(c, pattern, opts...)
+	r.observe("watch", err)
 	if err != nil {
 		return nil, err
 	}
@@ -142,7 +184,9 @@ func (r *natsKVRepo[T]) Set(c context.Context, _key string, value T) error {
 	if err != nil {
 		return errors.NewEf(err, "failed to marshal value")
 	}
-	if _, err := r.keyValue.Put(c, key, b); err != nil {
+	_, err = r.keyValue.Put(c, key, b)
+	r.observe("put", err)
+	if err != nil {
 		return errors.NewE(err)
 	}
 	return nil
@@ -151,6 +195,7 @@ func (r *natsKVRepo[T]) Set(c context.Context, _key string, value T) error {
 func (r *natsKVRepo[T]) Get(c context.Context, _key string) (T, error) {
 	key := sanitiseKey(_key)
 	get, err := r.keyValue.Get(c, key)
+	r.observe("get", err)
 	if err != nil {
 		var x T
 		return x, err
@@ -168,10 +213,58 @@ func (r *natsKVRepo[T]) Get(c context.Context, _key string) (T, error) {
 	return value.Data, err
 }
 
+func (r *natsKVRepo[T]) GetWithRevision(c context.Context, _key string) (T, uint64, error) {
+	key := sanitiseKey(_key)
+	get, err := r.keyValue.Get(c, key)
+	r.observe("get", err)
+	if err != nil {
+		var x T
+		return x, 0, err
+	}
+	var value Value[T]
+	if err := egob.Unmarshal(get.Value(), &value); err != nil {
+		return value.Data, 0, errors.NewEf(err, "failed to unmarshal value")
+	}
+	return value.Data, get.Revision(), nil
+}
+
+func (r *natsKVRepo[T]) Update(c context.Context, _key string, value T, revision uint64) (uint64, error) {
+	key := sanitiseKey(_key)
+	b, err := egob.Marshal(Value[T]{Data: value})
+	if err != nil {
+		return 0, errors.NewEf(err, "failed to marshal value")
+	}
+	rev, err := r.keyValue.Update(c, key, b, revision)
+	r.observe("update", err)
+	if err != nil {
+		return 0, errors.NewE(err)
+	}
+	return rev, nil
+}
+
+func (r *natsKVRepo[T]) Create(c context.Context, _key string, value T) (uint64, error) {
+	key := sanitiseKey(_key)
+	b, err := egob.Marshal(Value[T]{Data: value})
+	if err != nil {
+		return 0, errors.NewEf(err, "failed to marshal value")
+	}
+	rev, err := r.keyValue.Create(c, key, b)
+	r.observe("create", err)
+	if err != nil {
+		return 0, errors.NewE(err)
+	}
+	return rev, nil
+}
+
 func (r *natsKVRepo[T]) ErrKeyNotFound(err error) bool {
 	return errors.Is(err, jetstream.ErrKeyNotFound)
 }
 
+// ErrRevisionMismatch matches the wrong last sequence error, that both Update and Create fail with
+func (r *natsKVRepo[T]) ErrRevisionMismatch(err error) bool {
+	return errors.Is(err, jetstream.ErrKeyExists)
+}
+
 func sanitiseKey(key string) string {
 	return strings.ReplaceAll(key, ":", "-")
 }
@@ -186,14 +279,18 @@ func (r *natsKVRepo[T]) SetWithExpiry(c context.Context, _key string, value T, d
 	if err != nil {
 		return errors.NewEf(err, "failed to marshal value")
 	}
-	if _, err := r.keyValue.Put(c, key, b); err != nil {
+	_, err = r.keyValue.Put(c, key, b)
+	r.observe("put", err)
+	if err != nil {
 		return errors.NewE(err)
 	}
 	return nil
 }
 
 func (r *natsKVRepo[T]) Drop(c context.Context, key string) error {
-	return r.keyValue.Delete(c, sanitiseKey(key))
+	err := r.keyValue.Delete(c, sanitiseKey(key))
+	r.observe("delete", err)
+	return err
 }
 
 func (r *natsKVRepo[T]) ErrNoRecord(err error) bool {
@@ -226,6 +323,47 @@ func NewNatsKVRepo[T any](ctx context.Context, bucketName string, jc *nats.Jetst
 	}
 }
 
+// NewNatsKVRepoWithTTL opens the bucket, creating it when missing, with its values expiring after ttl. KV buckets can not
+// be updated in place, so an existing bucket with another TTL gets the max age of its underlying stream updated
+func NewNatsKVRepoWithTTL[T any](ctx context.Context, bucketName string, ttl time.Duration, jc *nats.JetstreamClient) (Repo[T], error) {
+	value, err := jc.Jetstream.KeyValue(ctx, bucketName)
+	if err != nil {
+		if !errors.Is(err, jetstream.ErrBucketNotFound) {
+			return nil, errors.NewE(err)
+		}
+
+		value, err = jc.Jetstream.CreateKeyValue(ctx, jetstream.KeyValueConfig{Bucket: bucketName, TTL: ttl})
+		if err != nil {
+			return nil, errors.NewE(err)
+		}
+		return &natsKVRepo[T]{value}, nil
+	}
+
+	status, err := value.Status(ctx)
+	if err != nil {
+		return nil, errors.NewE(err)
+	}
+
+	if status.TTL() != ttl {
+		stream, err := jc.Jetstream.Stream(ctx, "KV_"+bucketName)
+		if err != nil {
+			return nil, errors.NewE(err)
+		}
+
+		cfg := stream.CachedInfo().Config
+		cfg.MaxAge = ttl
+		if ttl > 0 && cfg.Duplicates > ttl {
+			cfg.Duplicates = ttl
+		}
+
+		if _, err := jc.Jetstream.UpdateStream(ctx, cfg); err != nil {
+			return nil, errors.NewE(err)
+		}
+	}
+
+	return &natsKVRepo[T]{value}, nil
+}
+
 func NewNatsKvRepoFx[T any](bucketName string) fx.Option {
 	return fx.Provide(func(jc *nats.JetstreamClient) (meter Repo[T], err error) {
 		return NewNatsKVRepo[T](context.TODO(), bucketName, jc)
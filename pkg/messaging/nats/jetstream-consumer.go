@@ -4,6 +4,7 @@ import (
 	"context"
 	"os"
 	"os/signal"
+	"time"
 
 	"github.com/kloudlite/kloudmeter/pkg/errors"
 
@@ -13,6 +14,8 @@ import (
 )
 
 type JetstreamConsumer struct {
+	// ctx is the context the consumer was created with, cancelling it stops consuming messages
+	ctx        context.Context
 	name       string
 	stream     string
 	client     *nats.JetstreamClient
@@ -25,6 +28,7 @@ func (jc *JetstreamConsumer) Consume(consumeFn func(msg *types.ConsumeMsg) error
 	cctx, err := jc.consumer.Consume(func(msg jetstream.Msg) {
 		mm, err := msg.Metadata()
 		if err != nil {
+			consumedMessages.WithLabelValues(jc.stream, jc.name, "nak").Inc()
 			if err := msg.Nak(); err != nil {
 				jc.client.Logger.Errorf(err, "while consuming message from subject: %s, sending NACK", msg.Subject())
 				return
@@ -32,7 +36,15 @@ func (jc *JetstreamConsumer) Consume(consumeFn func(msg *types.ConsumeMsg) error
 			return
 		}
 
+		consumerPending.WithLabelValues(jc.stream, jc.name).Set(float64(mm.NumPending))
+
+		start := time.Now()
+		defer func() {
+			consumeDuration.WithLabelValues(jc.stream, jc.name).Observe(time.Since(start).Seconds())
+		}()
+
 		if err = msg.InProgress(); err != nil {
+			consumedMessages.WithLabelValues(jc.stream, jc.name, "nak").Inc()
 			if err := msg.Nak(); err != nil {
 				jc.client.Logger.Errorf(err, "while consuming message from subject: %s, sending NACK", msg.Subject())
 				return
@@ -47,6 +59,7 @@ func (jc *JetstreamConsumer) Consume(consumeFn func(msg *types.ConsumeMsg) error
 		}); err != nil {
 			if opts.OnError == nil {
 				jc.client.Logger.Errorf(err, "while consuming message from subject: %s, sending NACK", msg.Subject())
+				consumedMessages.WithLabelValues(jc.stream, jc.name, "nak").Inc()
 				if err := msg.Nak(); err != nil {
 					jc.client.Logger.Errorf(err, "while consuming message from subject: %s, sending NACK", msg.Subject())
 					return
@@ -57,6 +70,7 @@ func (jc *JetstreamConsumer) Consume(consumeFn func(msg *types.ConsumeMsg) error
 			if opts.OnError != nil {
 				if err := opts.OnError(err); err != nil {
 					jc.client.Logger.Errorf(err, "while consuming message from subject: %s, sending NACK", msg.Subject())
+					consumedMessages.WithLabelValues(jc.stream, jc.name, "nak").Inc()
 					if err := msg.Nak(); err != nil {
 						jc.client.Logger.Errorf(err, "while consuming message from subject: %s, sending NACK", msg.Subject())
 						return
@@ -66,6 +80,7 @@ func (jc *JetstreamConsumer) Consume(consumeFn func(msg *types.ConsumeMsg) error
 			}
 		}
 
+		consumedMessages.WithLabelValues(jc.stream, jc.name, "ack").Inc()
 		if err := msg.Ack(); err != nil {
 			jc.client.Logger.Errorf(err, "while consuming message from subject: %s, sending ACK", msg.Subject())
 			return
@@ -82,8 +97,14 @@ func (jc *JetstreamConsumer) Consume(consumeFn func(msg *types.ConsumeMsg) error
 
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, os.Interrupt)
-	s := <-quit
-	return errors.Newf("os signal: %s received, stopped consuming messages", s)
+	defer signal.Stop(quit)
+
+	select {
+	case s := <-quit:
+		return errors.Newf("os signal: %s received, stopped consuming messages", s)
+	case <-jc.ctx.Done():
+		return nil
+	}
 }
 
 // Stop implements Consumer.
@@ -113,6 +134,7 @@ func NewJetstreamConsumer(ctx context.Context, jc *nats.JetstreamClient, args Je
 	}
 
 	return &JetstreamConsumer{
+		ctx:      ctx,
 		name:     args.ConsumerConfig.Name,
 		client:   jc,
 		consumer: c,
@@ -121,5 +143,11 @@ func NewJetstreamConsumer(ctx context.Context, jc *nats.JetstreamClient, args Je
 }
 
 func DeleteConsumer(ctx context.Context, jc *nats.JetstreamClient, consumer *JetstreamConsumer) error {
-	return jc.Jetstream.DeleteConsumer(ctx, consumer.stream, consumer.name)
+	return DeleteDurableConsumer(ctx, jc, consumer.stream, consumer.name)
+}
+
+// DeleteDurableConsumer deletes a durable consumer by name, whether or not it is being consumed by this process
+func DeleteDurableConsumer(ctx context.Context, jc *nats.JetstreamClient, stream string, name string) error {
+	consumerPending.DeleteLabelValues(stream, name)
+	return jc.Jetstream.DeleteConsumer(ctx, stream, name)
 }
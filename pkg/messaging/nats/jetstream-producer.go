@@ -31,22 +31,31 @@ func (c *JetstreamProducer) Stop(ctx context.Context) error {
 }
 
 // ProduceAsync implements messaging.Producer.
-func (c *JetstreamProducer) ProduceAsync(ctx context.Context, msg types.ProduceMsg) error {
-	pa, err := c.client.Jetstream.PublishAsync(msg.Subject, msg.Payload)
+// The returned channel receives the result of the message, once it is acknowledged, failed or ctx is done
+func (c *JetstreamProducer) ProduceAsync(ctx context.Context, msg types.ProduceMsg) (<-chan types.ProduceResult, error) {
+	var opts []jetstream.PublishOpt
+	if msg.MsgID != nil {
+		opts = append(opts, jetstream.WithMsgID(*msg.MsgID))
+	}
+
+	pa, err := c.client.Jetstream.PublishAsync(msg.Subject, msg.Payload, opts...)
 	if err != nil {
-		return errors.NewE(err)
+		return nil, errors.NewE(err)
 	}
 
+	result := make(chan types.ProduceResult, 1)
 	go func() {
-		fmt.Println("waiting for acknowledgement")
+		defer close(result)
 		select {
 		case ack := <-pa.Ok():
-			fmt.Println("Message Acknowledged, at stream: ", ack.Stream, " seq: ", ack.Sequence)
-		case <-pa.Err():
-			fmt.Println("Message Failed to be Acknowledged")
+			result <- types.ProduceResult{Stream: ack.Stream, Sequence: ack.Sequence, Duplicate: ack.Duplicate}
+		case err := <-pa.Err():
+			result <- types.ProduceResult{Err: errors.NewE(err)}
+		case <-ctx.Done():
+			result <- types.ProduceResult{Err: errors.NewE(ctx.Err())}
 		}
 	}()
-	return nil
+	return result, nil
 }
 
 // Produce implements messaging.Producer.
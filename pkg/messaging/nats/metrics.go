@@ -0,0 +1,24 @@
+package nats
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	consumedMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "kloudmeter_consumer_messages_total",
+		Help: "Messages handled by jetstream consumers, by consumer and result (ack or nak)",
+	}, []string{"stream", "consumer", "result"})
+
+	consumerPending = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "kloudmeter_consumer_pending_messages",
+		Help: "Messages of the stream not yet delivered to the consumer, as of its latest delivered message",
+	}, []string{"stream", "consumer"})
+
+	consumeDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "kloudmeter_consumer_message_duration_seconds",
+		Help:    "Time taken by jetstream consumers to handle a message",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"stream", "consumer"})
+)
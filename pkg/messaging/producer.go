@@ -9,7 +9,7 @@ import (
 
 type Producer interface {
 	Produce(ctx context.Context, msg types.ProduceMsg) error
-	ProduceAsync(ctx context.Context, msg types.ProduceMsg) error
+	ProduceAsync(ctx context.Context, msg types.ProduceMsg) (<-chan types.ProduceResult, error)
 
 	Stop(ctx context.Context) error
 }
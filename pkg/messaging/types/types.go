@@ -15,6 +15,16 @@ type ProduceMsg struct {
 
 type ProducerOutput struct{}
 
+// ProduceResult is the outcome of an async produce, once the stream acknowledged (or failed) the message
+type ProduceResult struct {
+	Stream   string
+	Sequence uint64
+
+	// Duplicate is set when the stream already had a message with the same MsgID, within its duplicate window
+	Duplicate bool
+	Err       error
+}
+
 type ConsumeMsg struct {
 	Subject   string
 	Timestamp time.Time